@@ -0,0 +1,150 @@
+package main
+
+import (
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/service"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 示例路径中使用的文件名
+const checkSampleFile = "PlexWarp.sample.strm"
+
+// stringList 可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// checkReport 检查报告，记录每一项的检查结果
+type checkReport struct {
+	passed, warned, failed int
+}
+
+func (r *checkReport) pass(format string, args ...interface{}) {
+	r.passed++
+	fmt.Printf("[PASS] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *checkReport) warn(format string, args ...interface{}) {
+	r.warned++
+	fmt.Printf("[WARN] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *checkReport) fail(format string, args ...interface{}) {
+	r.failed++
+	fmt.Printf("[FAIL] %s\n", fmt.Sprintf(format, args...))
+}
+
+// finish 输出检查汇总，返回退出码
+func (r *checkReport) finish() int {
+	fmt.Printf("\n检查完成：通过 %d 项，警告 %d 项，失败 %d 项\n", r.passed, r.warned, r.failed)
+	if r.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runCheck 检查配置及依赖服务，存在失败项时返回非零退出码
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	path := fs.String("config", configPath, "指定配置文件路径")
+	var samples stringList
+	fs.Var(&samples, "path", "额外用于检查映射规则的示例路径，可重复指定")
+	fs.Parse(args)
+
+	report := &checkReport{}
+
+	// 加载并校验配置
+	if err := config.Init(*path); err != nil {
+		report.fail("配置文件: %v", err)
+		return report.finish()
+	}
+	report.pass("配置文件: %s", config.ConfigFile)
+	cfg := config.Current()
+
+	// 媒体挂载路径
+	for _, mountPath := range cfg.Plex302.MediaMountPaths {
+		info, err := os.Stat(mountPath)
+		switch {
+		case err != nil:
+			report.fail("媒体挂载路径: %s 不可访问: %v", mountPath, err)
+		case !info.IsDir():
+			report.fail("媒体挂载路径: %s 不是目录", mountPath)
+		default:
+			report.pass("媒体挂载路径: %s", mountPath)
+		}
+	}
+
+	// Plex连接及令牌
+	service.InitPlexService()
+	for _, upstream := range service.Upstreams() {
+		name := fmt.Sprintf("Plex服务器 %s", upstream.Name)
+		if err := upstream.CheckConnection(); err != nil {
+			report.fail("%s: %v", name, err)
+			continue
+		}
+		report.pass("%s: %s", name, upstream.BaseURL)
+		if upstream.Token == "" {
+			report.warn("%s 令牌: 未配置，需确保PlexWarp所在网络无需认证即可访问Plex", name)
+		} else if err := upstream.CheckToken(); err != nil {
+			report.fail("%s 令牌: %v", name, err)
+		} else {
+			report.pass("%s 令牌: 有效", name)
+		}
+	}
+
+	// 映射规则：为每条规则生成示例路径，确认命中的是该规则本身而不是被前面的规则遮蔽
+	strmService := service.NewStrmService()
+	for i, rule := range cfg.Symlink.Rules {
+		checkMappingRule(report, strmService, "symlink", i, rule.Path, cfg.Symlink.Enable)
+	}
+	for i, rule := range cfg.PathMapping.Rules {
+		checkMappingRule(report, strmService, "path_mapping", i, rule.From, cfg.PathMapping.Enable)
+	}
+	for _, sample := range samples {
+		result := strmService.MapPath(sample)
+		if result.RuleType == "" {
+			report.warn("示例路径: %s 未命中任何映射规则", sample)
+		} else {
+			report.pass("示例路径: %s -> %s (%s.rules[%d])", sample, result.Mapped, result.RuleType, result.RuleIndex)
+		}
+	}
+
+	return report.finish()
+}
+
+// checkMappingRule 检查单条映射规则
+func checkMappingRule(report *checkReport, strmService *service.StrmService, ruleType string, index int, prefix string, enabled bool) {
+	name := fmt.Sprintf("%s.rules[%d]", ruleType, index)
+	if !enabled {
+		report.warn("映射规则 %s: %s.enable 未开启，规则不会生效", name, ruleType)
+		return
+	}
+
+	sample := strings.TrimSuffix(prefix, "/") + "/" + checkSampleFile
+	result := strmService.MapPath(sample)
+
+	switch {
+	case result.RuleType != ruleType || result.RuleIndex != index:
+		report.fail("映射规则 %s: 被 %s.rules[%d] 遮蔽，永远不会生效", name, result.RuleType, result.RuleIndex)
+	case service.IsHTTPLink(result.Mapped):
+		report.pass("映射规则 %s: %s -> %s", name, sample, result.Mapped)
+	default:
+		// 映射到本地路径时检查目标目录是否存在
+		target := strings.TrimSuffix(result.Mapped, "/"+checkSampleFile)
+		if _, err := os.Stat(target); err != nil {
+			report.warn("映射规则 %s: %s -> %s，目标路径不可访问: %v", name, sample, result.Mapped, err)
+		} else {
+			report.pass("映射规则 %s: %s -> %s", name, sample, result.Mapped)
+		}
+	}
+}
@@ -17,16 +17,35 @@ const (
 	LOG_LEVEL_WARN  = "warn"
 	LOG_LEVEL_ERROR = "error"
 
+	// 日志格式
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
 	// 过滤模式
 	FILTER_MODE_ALLOW = "allow"
 	FILTER_MODE_DENY  = "deny"
+
+	// 链接匹配类型
+	MATCH_TYPE_STARTSWITH = "startswith"
+	MATCH_TYPE_ENDSWITH   = "endswith"
+	MATCH_TYPE_CONTAINS   = "contains"
+	MATCH_TYPE_REGEX      = "regex"
+
+	// STRM链接处理动作
+	STRM_ACTION_PROXY    = "proxy"
+	STRM_ACTION_REDIRECT = "redirect"
+
+	// 主Plex服务器名称，对应 plex_server.addr 及 plex_server.auth
+	PRIMARY_UPSTREAM = "primary"
 )
 
 // PlexServerType Plex服务器类型
 type PlexServerType string
 
 const (
-	PlexServerTypePlex PlexServerType = "plex"
+	PlexServerTypePlex     PlexServerType = "plex"
+	PlexServerTypeJellyfin PlexServerType = "jellyfin"
+	PlexServerTypeEmby     PlexServerType = "emby"
 )
 
 // FilterMode 过滤模式
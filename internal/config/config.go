@@ -2,45 +2,55 @@ package config
 
 import (
 	"PlexWarp/constants"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
 var (
 	// 基础配置
-	Port       int    = constants.DEFAULT_PORT
-	Host       string = constants.DEFAULT_HOST
 	RootDir    string // 程序根目录
 	ConfigDir  string // 配置文件目录
 	LogDir     string // 日志文件目录
 	StaticDir  string // 静态文件目录
+	CacheDir   string // 缓存文件目录
 	ConfigFile string // 配置文件路径
 
-	// Plex服务器配置
-	PlexServer PlexServerSetting
-
-	// 日志配置
-	Logger LoggerSetting
-
-	// 客户端过滤配置
-	ClientFilter ClientFilterSetting
-
-	// Plex302重定向配置
-	Plex302 Plex302Setting
+	// 当前生效的配置快照
+	current atomic.Pointer[Snapshot]
+	// 最近一次加载配置时的警告
+	warnings atomic.Pointer[[]string]
+)
 
-	// 路径映射配置
-	PathMapping PathMappingConfig
+// 已更名的配置项，配置文件中仍使用旧名称时按新名称读取
+var renamedKeys = map[string]string{
+	"symlink_rules":         "symlink",
+	"client_filter.clients": "client_filter.client_list",
+}
 
-	// 软链接配置
-	Symlink SymlinkConfig
+// Current 获取当前生效的配置快照
+// 快照创建后不再修改，热重载时整体替换，调用方不应修改其内容
+func Current() *Snapshot {
+	return current.Load()
+}
 
-	// STRM重定向配置
-	StrmRedirect StrmRedirectConfig
-)
+// Warnings 获取最近一次成功加载配置时的警告，如未知或已更名的配置项
+func Warnings() []string {
+	if w := warnings.Load(); w != nil {
+		return *w
+	}
+	return nil
+}
 
 // Init 初始化配置
 func Init(configPath string) error {
@@ -55,6 +65,7 @@ func Init(configPath string) error {
 	ConfigDir = filepath.Join(RootDir, "config")
 	LogDir = filepath.Join(RootDir, "logs")
 	StaticDir = filepath.Join(RootDir, "static")
+	CacheDir = filepath.Join(RootDir, "cache")
 
 	// 创建必要目录
 	if err := createDir(ConfigDir); err != nil {
@@ -66,6 +77,9 @@ func Init(configPath string) error {
 	if err := createDir(StaticDir); err != nil {
 		return err
 	}
+	if err := createDir(CacheDir); err != nil {
+		return err
+	}
 
 	// 设置配置文件路径
 	if configPath != "" {
@@ -80,131 +94,214 @@ func Init(configPath string) error {
 
 // loadConfig 加载配置文件
 func loadConfig() error {
-	viper.SetConfigFile(ConfigFile)
-	viper.SetConfigType("yaml")
-
-	// 设置默认值
-	setDefaults()
-
-	// 读取配置文件
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// 配置文件不存在，使用默认配置并创建配置文件
-			if err := viper.SafeWriteConfig(); err != nil {
-				return fmt.Errorf("创建配置文件失败: %v", err)
-			}
-		} else {
-			return fmt.Errorf("读取配置文件失败: %v", err)
+	// 配置文件不存在时使用默认配置创建，此时尚未启用环境变量覆盖，避免将环境变量中的密钥写入文件
+	if _, err := os.Stat(ConfigFile); os.IsNotExist(err) {
+		v := viper.New()
+		setDefaults(v)
+		v.SetConfigType("yaml")
+		if err := v.SafeWriteConfigAs(ConfigFile); err != nil {
+			return fmt.Errorf("创建配置文件失败: %v", err)
 		}
 	}
 
-	// 解析配置到结构体
-	Port = viper.GetInt("port")
-	Host = viper.GetString("host")
-
-	// Plex服务器配置
-	PlexServer.ADDR = viper.GetString("plex_server.addr")
-	PlexServer.AUTH = viper.GetString("plex_server.auth")
-
-	// 日志配置
-	Logger.AccessLogger.Console = viper.GetBool("logger.access_logger.console")
-	Logger.AccessLogger.File = viper.GetBool("logger.access_logger.file")
-	Logger.ServiceLogger.Console = viper.GetBool("logger.service_logger.console")
-	Logger.ServiceLogger.File = viper.GetBool("logger.service_logger.file")
-
-	// 客户端过滤配置
-	ClientFilter.Enable = viper.GetBool("client_filter.enable")
-	ClientFilter.Mode = viper.GetString("client_filter.mode")
-	ClientFilter.Clients = viper.GetStringSlice("client_filter.client_list")
-
-	// Plex302重定向配置
-	Plex302.Enable = viper.GetBool("plex302.enable")
-	Plex302.MediaMountPaths = viper.GetStringSlice("plex302.media_mount_paths")
-	Plex302.TranscodeEnable = viper.GetBool("plex302.transcode_enable")
-	Plex302.FallbackOriginal = viper.GetBool("plex302.fallback_original")
-	Plex302.CheckLinkValidity = viper.GetBool("plex302.check_link_validity")
-
-	// 加载路径映射规则
-	pathMappingData := viper.Get("path_mapping.rules")
-	if mappingSlice, ok := pathMappingData.([]interface{}); ok {
-		for _, item := range mappingSlice {
-			if mapping, ok := item.(map[string]interface{}); ok {
-				rule := PathMappingRule{
-					From: mapping["from"].(string),
-					To:   mapping["to"].(string),
-				}
-				PathMapping.Rules = append(PathMapping.Rules, rule)
-			}
-		}
+	data, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %v", err)
 	}
+	loadedSum = sha256.Sum256(data)
 
-	// 加载软链接规则
-	symlinkRulesData := viper.Get("symlink.rules")
-	if rulesSlice, ok := symlinkRulesData.([]interface{}); ok {
-		for _, item := range rulesSlice {
-			if rule, ok := item.(map[string]interface{}); ok {
-				symlinkRule := SymlinkRule{
-					Path:   rule["path"].(string),
-					Target: rule["target"].(string),
-				}
-				Symlink.Rules = append(Symlink.Rules, symlinkRule)
-			}
-		}
+	snapshot, warns, err := readConfig(data)
+	if err != nil {
+		return err
+	}
+	current.Store(snapshot)
+	warnings.Store(&warns)
+	return nil
+}
+
+// readConfig 解析配置文件内容，应用环境变量及命令行覆盖后构建并校验配置快照
+// 每次使用新的viper实例，校验失败时不影响当前生效的配置
+func readConfig(data []byte) (*Snapshot, []string, error) {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	bindEnv(v)
+	if err := applyOverrides(v); err != nil {
+		return nil, nil, err
 	}
 
-	// 加载STRM重定向规则
-	strmRedirectData := viper.Get("strm_redirect.last_link_rules")
-	if rulesSlice, ok := strmRedirectData.([]interface{}); ok {
-		for _, item := range rulesSlice {
-			if rule, ok := item.(map[string]interface{}); ok {
-				strmRule := StrmRedirectRule{
-					MatchType: rule["match_type"].(string),
-					Patterns:  rule["patterns"].([]string),
-					Action:    rule["action"].(string),
-				}
-				StrmRedirect.LastLinkRules = append(StrmRedirect.LastLinkRules, strmRule)
+	snapshot, warns, err := buildSnapshot(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validate(snapshot); err != nil {
+		return nil, nil, err
+	}
+	return snapshot, warns, nil
+}
+
+// buildSnapshot 根据viper中的配置构建配置快照
+// 类型不匹配时返回错误；未知的配置项不影响启动，与已更名的配置项一起作为警告返回
+func buildSnapshot(v *viper.Viper) (*Snapshot, []string, error) {
+	s := &Snapshot{}
+	var metadata mapstructure.Metadata
+	if err := v.Unmarshal(s, func(dc *mapstructure.DecoderConfig) {
+		dc.Metadata = &metadata
+		dc.DecodeHook = decodeHook()
+	}); err != nil {
+		return nil, nil, fmt.Errorf("解析配置失败: %v", err)
+	}
+
+	warns := []string{}
+	sort.Strings(metadata.Unused)
+	for _, key := range metadata.Unused {
+		newKey, ok := renamedKeys[key]
+		switch {
+		case !ok:
+			warns = append(warns, fmt.Sprintf("未知的配置项 %s，已忽略", key))
+		case v.InConfig(newKey) || isOverridden(newKey):
+			warns = append(warns, fmt.Sprintf("配置项 %s 已更名为 %s，两者同时存在，使用 %s", key, newKey, newKey))
+		default:
+			if err := decodeRenamed(v, s, key, newKey); err != nil {
+				return nil, nil, err
 			}
+			warns = append(warns, fmt.Sprintf("配置项 %s 已更名为 %s，请修改配置文件", key, newKey))
 		}
 	}
 
+	s.Server.Type = strings.ToLower(s.Server.Type)
+	s.Logger.Format = strings.ToLower(s.Logger.Format)
+	s.Logger.AccessLogger.Level = strings.ToLower(s.Logger.AccessLogger.Level)
+	s.Logger.ServiceLogger.Level = strings.ToLower(s.Logger.ServiceLogger.Level)
+
+	// 兼容旧版过滤模式名称
+	filter := &s.ClientFilter
+	switch filter.Mode = strings.ToLower(filter.Mode); filter.Mode {
+	case "whitelist":
+		filter.Mode = constants.FILTER_MODE_ALLOW
+	case "blacklist":
+		filter.Mode = constants.FILTER_MODE_DENY
+	}
+	filter.DefaultAction = strings.ToLower(filter.DefaultAction)
+	for i := range filter.Rules {
+		filter.Rules[i].Action = strings.ToLower(filter.Rules[i].Action)
+	}
+
+	return s, warns, nil
+}
+
+// decodeHook 配置项的类型转换
+func decodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		structuredStringHook(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)
+}
+
+// decodeRenamed 将配置文件中旧名称的配置项解析到新名称对应的字段
+func decodeRenamed(v *viper.Viper, s *Snapshot, oldKey, newKey string) error {
+	field, err := lookupField(reflect.ValueOf(s).Elem(), newKey)
+	if err != nil {
+		return err
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           field.Addr().Interface(),
+		DecodeHook:       decodeHook(),
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		return err
+	}
+	if err := decoder.Decode(v.Get(oldKey)); err != nil {
+		return fmt.Errorf("解析配置项 %s 失败: %v", oldKey, err)
+	}
 	return nil
 }
 
 // setDefaults 设置默认配置值
-func setDefaults() {
-	viper.SetDefault("port", constants.DEFAULT_PORT)
-	viper.SetDefault("host", constants.DEFAULT_HOST)
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("port", constants.DEFAULT_PORT)
+	v.SetDefault("host", constants.DEFAULT_HOST)
+
+	// 退出默认配置
+	v.SetDefault("shutdown.drain_timeout", "30s")
+
+	// 媒体服务器默认配置
+	v.SetDefault("server.type", string(constants.PlexServerTypePlex))
 
 	// Plex服务器默认配置
-	viper.SetDefault("plex_server.addr", "http://localhost:32400")
-	viper.SetDefault("plex_server.auth", "")
+	v.SetDefault("plex_server.addr", "http://localhost:32400")
+	v.SetDefault("plex_server.auth", "")
+	v.SetDefault("plex_server.max_idle_conns", 100)
+	v.SetDefault("plex_server.timeouts.dial", "10s")
+	v.SetDefault("plex_server.timeouts.tls_handshake", "10s")
+	v.SetDefault("plex_server.timeouts.response_header", "60s")
+	v.SetDefault("plex_server.timeouts.idle_conn", "90s")
+	v.SetDefault("plex_server.timeouts.api", "30s")
+	v.SetDefault("plex_server.timeouts.media", "0s")
+	v.SetDefault("plex_server.timeouts.transcode", "0s")
+	v.SetDefault("plex_server.upstreams", []map[string]interface{}{})
+	v.SetDefault("plex_server.health_check.enable", true)
+	v.SetDefault("plex_server.health_check.interval", "30s")
+	v.SetDefault("plex_server.health_check.timeout", "5s")
+	v.SetDefault("plex_server.routes", []map[string]string{})
+
+	// 接口认证默认配置
+	v.SetDefault("auth.api_key", "")
+	v.SetDefault("auth.basic.username", "")
+	v.SetDefault("auth.basic.password", "")
+	v.SetDefault("auth.plex_owner", false)
 
 	// 日志默认配置
-	viper.SetDefault("logger.access_logger.console", true)
-	viper.SetDefault("logger.access_logger.file", true)
-	viper.SetDefault("logger.service_logger.console", true)
-	viper.SetDefault("logger.service_logger.file", true)
+	v.SetDefault("logger.format", "text")
+	for _, logger := range []string{"access_logger", "service_logger"} {
+		v.SetDefault("logger."+logger+".console", true)
+		v.SetDefault("logger."+logger+".file", true)
+		v.SetDefault("logger."+logger+".level", "info")
+		v.SetDefault("logger."+logger+".rotate.max_size", 100)
+		v.SetDefault("logger."+logger+".rotate.interval", "0s")
+		v.SetDefault("logger."+logger+".rotate.max_backups", 7)
+		v.SetDefault("logger."+logger+".rotate.max_age", 30)
+		v.SetDefault("logger."+logger+".rotate.compress", true)
+	}
 
 	// 客户端过滤默认配置
-	viper.SetDefault("client_filter.enable", false)
-	viper.SetDefault("client_filter.mode", "allow")
-	viper.SetDefault("client_filter.client_list", []string{})
+	v.SetDefault("client_filter.enable", false)
+	v.SetDefault("client_filter.mode", "allow")
+	v.SetDefault("client_filter.client_list", []string{})
+	v.SetDefault("client_filter.default_action", "")
+	v.SetDefault("client_filter.trusted_proxies", []string{})
+	v.SetDefault("client_filter.rules", []map[string]interface{}{})
 
 	// Plex302重定向默认配置
-	viper.SetDefault("plex302.enable", false)
-	viper.SetDefault("plex302.media_mount_paths", []string{"/mnt"})
-	viper.SetDefault("plex302.transcode_enable", true)
-	viper.SetDefault("plex302.fallback_original", true)
-	viper.SetDefault("plex302.check_link_validity", false)
+	v.SetDefault("plex302.enable", false)
+	v.SetDefault("plex302.media_mount_paths", []string{"/mnt"})
+	v.SetDefault("plex302.transcode_enable", true)
+	v.SetDefault("plex302.fallback_original", true)
+	v.SetDefault("plex302.check_link_validity", false)
+
+	// 分段索引默认配置
+	v.SetDefault("part_index.enable", true)
+	v.SetDefault("part_index.ttl", "6h")
+	v.SetDefault("part_index.refresh_interval", "30m")
+	v.SetDefault("part_index.persist", false)
 
 	// 路径映射默认配置
-	viper.SetDefault("path_mapping.rules", []map[string]string{})
+	v.SetDefault("path_mapping.enable", true)
+	v.SetDefault("path_mapping.rules", []map[string]string{})
 
 	// 软链接默认配置
-	viper.SetDefault("symlink.rules", []map[string]string{})
+	v.SetDefault("symlink.enable", true)
+	v.SetDefault("symlink.rules", []map[string]string{})
 
 	// STRM重定向默认配置
-	viper.SetDefault("strm_redirect.rules", []map[string]string{})
+	v.SetDefault("strm_redirect.enable", true)
+	v.SetDefault("strm_redirect.last_link_rules", []map[string]interface{}{})
 }
 
 // createDir 创建目录
@@ -217,5 +314,6 @@ func createDir(dir string) error {
 
 // ListenAddr 返回监听地址
 func ListenAddr() string {
-	return Host + ":" + strconv.Itoa(Port)
+	snapshot := Current()
+	return snapshot.Host + ":" + strconv.Itoa(snapshot.Port)
 }
\ No newline at end of file
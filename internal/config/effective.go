@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+// Effective 获取当前生效的完整配置，已合并默认值、配置文件、环境变量及命令行覆盖项
+// 返回值以配置项名称为键，敏感配置项隐藏取值
+func Effective() map[string]interface{} {
+	return exportValue("", reflect.ValueOf(*Current())).(map[string]interface{})
+}
+
+// exportValue 递归导出配置值
+func exportValue(key string, value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Struct:
+		result := make(map[string]interface{}, value.NumField())
+		for i := 0; i < value.NumField(); i++ {
+			name := fieldKey(value.Type().Field(i))
+			childKey := name
+			if key != "" {
+				childKey = key + "." + name
+			}
+			result[name] = exportValue(childKey, value.Field(i))
+		}
+		return result
+	case reflect.Slice:
+		list := make([]interface{}, value.Len())
+		for i := range list {
+			list[i] = exportValue(key, value.Index(i))
+		}
+		return list
+	}
+
+	if isSecretKey(key) {
+		if value.IsZero() {
+			return ""
+		}
+		return "******"
+	}
+	if d, ok := value.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return value.Interface()
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// 环境变量前缀，如 plex_server.addr 对应 PLEXWARP_PLEX_SERVER_ADDR
+const EnvPrefix = "PLEXWARP"
+
+// 从文件读取取值的环境变量后缀，如 PLEXWARP_PLEX_SERVER_AUTH_FILE=/run/secrets/plex_token
+const envFileSuffix = "_FILE"
+
+// 命令行指定的配置覆盖项，优先级高于环境变量及配置文件
+var cliOverrides = make(map[string]string)
+
+// SetOverride 通过命令行覆盖配置项，格式为 key=value，如 plex_server.addr=http://plex:32400
+// 需在 Init 之前调用
+func SetOverride(assignment string) error {
+	key, value, ok := strings.Cut(assignment, "=")
+	key = strings.ToLower(strings.TrimSpace(key))
+	if !ok || key == "" {
+		return fmt.Errorf("无效的配置覆盖 %q，应为 key=value 形式", assignment)
+	}
+	cliOverrides[key] = value
+	return nil
+}
+
+// EnvName 获取配置项对应的环境变量名
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// bindEnv 启用环境变量覆盖，必须在设置默认值之后调用
+func bindEnv(v *viper.Viper) {
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+}
+
+// applyOverrides 应用 _FILE 环境变量及命令行覆盖项
+// 覆盖项在配置文件重载后依然生效
+func applyOverrides(v *viper.Viper) error {
+	for _, key := range v.AllKeys() {
+		file := os.Getenv(EnvName(key) + envFileSuffix)
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("读取 %s 失败: %v", EnvName(key)+envFileSuffix, err)
+		}
+		v.Set(key, strings.TrimSpace(string(data)))
+	}
+
+	known := make(map[string]bool)
+	for _, key := range v.AllKeys() {
+		known[key] = true
+	}
+	keys := make([]string, 0, len(cliOverrides))
+	for key := range cliOverrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !known[key] {
+			return fmt.Errorf("无法覆盖未知的配置项: %s", key)
+		}
+		v.Set(key, cliOverrides[key])
+	}
+	return nil
+}
+
+// structuredStringHook 将以 [ 或 { 开头的字符串按YAML解析，
+// 使环境变量及命令行也能覆盖列表、规则等结构化配置项
+func structuredStringHook() mapstructure.DecodeHookFuncType {
+	return func(from, to reflect.Type, data interface{}) (interface{}, error) {
+		if from.Kind() != reflect.String {
+			return data, nil
+		}
+		switch to.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Struct:
+		default:
+			return data, nil
+		}
+
+		raw := strings.TrimSpace(data.(string))
+		if !strings.HasPrefix(raw, "[") && !strings.HasPrefix(raw, "{") {
+			return data, nil
+		}
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, fmt.Errorf("解析结构化取值失败: %v", err)
+		}
+		return value, nil
+	}
+}
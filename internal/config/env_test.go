@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"port", "PLEXWARP_PORT"},
+		{"plex_server.addr", "PLEXWARP_PLEX_SERVER_ADDR"},
+		{"logger.access_logger.rotate.max_size", "PLEXWARP_LOGGER_ACCESS_LOGGER_ROTATE_MAX_SIZE"},
+	}
+	for _, tt := range tests {
+		if got := EnvName(tt.key); got != tt.want {
+			t.Errorf("EnvName(%q) = %q，预期 %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSetOverride(t *testing.T) {
+	tests := []struct {
+		assignment string
+		key        string
+		value      string
+		wantErr    bool
+	}{
+		{assignment: "plex_server.addr=http://plex:32400", key: "plex_server.addr", value: "http://plex:32400"},
+		{assignment: " Port =8080", key: "port", value: "8080"},
+		{assignment: "plex_server.auth=", key: "plex_server.auth", value: ""},
+		{assignment: "auth.api_key=a=b", key: "auth.api_key", value: "a=b"},
+		{assignment: "port", wantErr: true},
+		{assignment: "=8080", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.assignment, func(t *testing.T) {
+			resetOverrides(t)
+			err := SetOverride(tt.assignment)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("预期返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("返回错误: %v", err)
+			}
+			if value, ok := cliOverrides[tt.key]; !ok || value != tt.value {
+				t.Errorf("覆盖项 %s = %q，预期 %q", tt.key, value, tt.value)
+			}
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "plex_token")
+	if err := os.WriteFile(secretFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		env     map[string]string
+		cli     []string
+		check   func(s *Snapshot) (got, want interface{})
+		wantErr string
+	}{
+		{
+			name: "环境变量覆盖配置文件",
+			config: `
+plex_server:
+  addr: http://file:32400
+`,
+			env:   map[string]string{"PLEXWARP_PLEX_SERVER_ADDR": "http://env:32400"},
+			check: func(s *Snapshot) (interface{}, interface{}) { return s.PlexServer.ADDR, "http://env:32400" },
+		},
+		{
+			name:  "从文件读取取值",
+			env:   map[string]string{"PLEXWARP_PLEX_SERVER_AUTH_FILE": secretFile},
+			check: func(s *Snapshot) (interface{}, interface{}) { return s.PlexServer.AUTH, "file-token" },
+		},
+		{
+			name:  "命令行优先于环境变量",
+			env:   map[string]string{"PLEXWARP_PORT": "8080"},
+			cli:   []string{"port=9090"},
+			check: func(s *Snapshot) (interface{}, interface{}) { return s.Port, 9090 },
+		},
+		{
+			name: "逗号分隔的列表",
+			cli:  []string{"plex302.media_mount_paths=/mnt/a,/mnt/b"},
+			check: func(s *Snapshot) (interface{}, interface{}) {
+				return s.Plex302.MediaMountPaths, []string{"/mnt/a", "/mnt/b"}
+			},
+		},
+		{
+			name: "YAML形式的规则列表",
+			env:  map[string]string{"PLEXWARP_PATH_MAPPING_RULES": "[{from: /mnt/a, to: /data/a}]"},
+			check: func(s *Snapshot) (interface{}, interface{}) {
+				return s.PathMapping.Rules, []PathMappingRule{{From: "/mnt/a", To: "/data/a"}}
+			},
+		},
+		{
+			name:    "未知的配置项",
+			cli:     []string{"plex_server.adress=http://plex:32400"},
+			wantErr: "无法覆盖未知的配置项",
+		},
+		{
+			name:    "取值文件不存在",
+			env:     map[string]string{"PLEXWARP_PLEX_SERVER_AUTH_FILE": filepath.Join(t.TempDir(), "missing")},
+			wantErr: "PLEXWARP_PLEX_SERVER_AUTH_FILE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetOverrides(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			for _, assignment := range tt.cli {
+				if err := SetOverride(assignment); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			v := viper.New()
+			setDefaults(v)
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(tt.config)); err != nil {
+				t.Fatalf("解析测试配置失败: %v", err)
+			}
+			bindEnv(v)
+			err := applyOverrides(v)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("预期返回包含 %q 的错误，实际: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("返回错误: %v", err)
+			}
+
+			snapshot, _, err := buildSnapshot(v)
+			if err != nil {
+				t.Fatalf("构建配置快照失败: %v", err)
+			}
+			if got, want := tt.check(snapshot); !reflect.DeepEqual(got, want) {
+				t.Errorf("取值为 %#v，预期 %#v", got, want)
+			}
+		})
+	}
+}
+
+// resetOverrides 清空命令行覆盖项，测试结束后恢复
+func resetOverrides(t *testing.T) {
+	saved := cliOverrides
+	cliOverrides = make(map[string]string)
+	t.Cleanup(func() { cliOverrides = saved })
+}
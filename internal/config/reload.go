@@ -0,0 +1,255 @@
+package config
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// 需要重启才能生效的配置项前缀
+var restartRequiredKeys = []string{
+	"port",
+	"host",
+	"logger",
+	"server.type",
+	"plex_server.max_idle_conns",
+	"plex_server.timeouts.dial",
+	"plex_server.timeouts.tls_handshake",
+	"plex_server.timeouts.response_header",
+	"plex_server.timeouts.idle_conn",
+	"part_index",
+}
+
+// 日志中需要隐藏取值的配置项名称
+var secretKeys = []string{"auth", "token", "password", "api_key"}
+
+// 配置文件变化后等待的时间，编辑器保存时通常会触发多个事件，合并为一次重载
+const watchDebounce = 200 * time.Millisecond
+
+// 保证同一时间只有一次重载或规则修改
+var reloadMu sync.Mutex
+
+// 最近一次加载的配置文件内容摘要，由 reloadMu 保护
+// 监听到的文件内容与之相同时（如通过管理接口修改规则后已重载）不再重复重载
+var loadedSum [sha256.Size]byte
+
+// Reload 重新读取配置文件，校验通过后替换当前配置快照
+// 返回发生变化的配置项描述，校验失败时保留原配置并返回错误
+func Reload() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return reloadLocked()
+}
+
+// reloadLocked 重新读取配置文件，调用方需持有 reloadMu
+func reloadLocked() ([]string, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	loadedSum = sha256.Sum256(data)
+
+	snapshot, warns, err := readConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return storeSnapshot(snapshot, warns), nil
+}
+
+// storeSnapshot 替换当前配置快照，返回发生变化的配置项描述，调用方需持有 reloadMu
+func storeSnapshot(snapshot *Snapshot, warns []string) []string {
+	warnings.Store(&warns)
+	changes := diffSnapshot(Current(), snapshot)
+	if len(changes) > 0 {
+		current.Store(snapshot)
+	}
+	return changes
+}
+
+// configFileSum 计算配置文件内容摘要，读取失败时返回零值
+func configFileSum() [sha256.Size]byte {
+	data, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// Watch 监听配置文件变化并自动重载，每次重载后通过 callback 返回变化项或错误
+// 监听配置文件所在目录，以支持编辑器及原子替换方式的写入；配置文件为软链接时同时监听链接指向的目录
+func Watch(callback func(changes []string, err error)) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		callback(nil, fmt.Errorf("监听配置文件失败: %v", err))
+		return
+	}
+
+	configPath, _ := filepath.Abs(ConfigFile)
+	realPath := resolveConfigPath(configPath)
+	dirs := []string{filepath.Dir(configPath)}
+	if dir := filepath.Dir(realPath); dir != dirs[0] {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			callback(nil, fmt.Errorf("监听配置文件失败: %v", err))
+			return
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				// 软链接指向变化时（如 Kubernetes ConfigMap 更新）事件名称不是配置文件本身
+				name := filepath.Clean(event.Name)
+				resolved := resolveConfigPath(configPath)
+				if name != configPath && name != realPath && resolved == realPath {
+					continue
+				}
+				realPath = resolved
+
+				if timer == nil {
+					timer = time.AfterFunc(watchDebounce, func() {
+						changes, reloaded, err := reloadIfChanged()
+						if reloaded {
+							callback(changes, err)
+						}
+					})
+				} else {
+					timer.Reset(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				callback(nil, fmt.Errorf("监听配置文件失败: %v", err))
+			}
+		}
+	}()
+}
+
+// resolveConfigPath 获取配置文件软链接指向的路径，解析失败时返回原路径
+func resolveConfigPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// reloadIfChanged 配置文件内容与最近一次加载时不同时重新加载，返回是否进行了重载
+func reloadIfChanged() ([]string, bool, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if configFileSum() == loadedSum {
+		return nil, false, nil
+	}
+	changes, err := reloadLocked()
+	return changes, true, err
+}
+
+// diffSnapshot 比较两个配置快照，返回发生变化的配置项描述
+func diffSnapshot(before, after *Snapshot) []string {
+	var changes []string
+	diffValue("", reflect.ValueOf(*before), reflect.ValueOf(*after), &changes)
+	return changes
+}
+
+// diffValue 递归比较结构体字段
+func diffValue(prefix string, before, after reflect.Value, changes *[]string) {
+	if before.Kind() == reflect.Struct {
+		for i := 0; i < before.NumField(); i++ {
+			key := fieldKey(before.Type().Field(i))
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			diffValue(key, before.Field(i), after.Field(i), changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(before.Interface(), after.Interface()) {
+		return
+	}
+
+	change := fmt.Sprintf("%s: %v -> %v", prefix, displayValue(prefix, before), displayValue(prefix, after))
+	if isRestartRequired(prefix) {
+		change += "（需重启生效）"
+	}
+	*changes = append(*changes, change)
+}
+
+// fieldKey 获取字段对应的配置键名
+func fieldKey(field reflect.StructField) string {
+	if tag := field.Tag.Get("mapstructure"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	return toSnakeCase(field.Name)
+}
+
+// displayValue 获取用于日志展示的配置值，敏感配置项隐藏取值
+func displayValue(key string, value reflect.Value) interface{} {
+	if isSecretKey(key) {
+		if value.IsZero() {
+			return `""`
+		}
+		return "******"
+	}
+	// 列表等复合配置项中可能包含敏感配置项
+	return exportValue(key, value)
+}
+
+// isSecretKey 判断配置项是否为敏感配置项
+func isSecretKey(key string) bool {
+	name := key[strings.LastIndex(key, ".")+1:]
+	for _, secret := range secretKeys {
+		if name == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// isRestartRequired 判断配置项是否需要重启才能生效
+func isRestartRequired(key string) bool {
+	for _, prefix := range restartRequiredKeys {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// toSnakeCase 将字段名转换为下划线命名，如 MaxIdleConns -> max_idle_conns、TLSHandshake -> tls_handshake
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
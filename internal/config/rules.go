@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrOverridden 配置项由环境变量或命令行指定，修改配置文件不会生效
+var ErrOverridden = errors.New("配置项由环境变量或命令行指定")
+
+// UpdateRules 修改规则列表并写回配置文件，返回发生变化的配置项描述
+// key 为规则列表的配置项，如 path_mapping.rules，T 需与配置快照中规则的类型一致；
+// mutate 接收配置文件中当前的规则列表副本并返回修改后的列表，修改期间持有重载锁，并发修改不会互相覆盖
+// 修改后的整个配置文件校验失败时不写入文件，返回 ValidationErrors
+func UpdateRules[T any](key string, mutate func(rules []T) ([]T, error)) ([]string, error) {
+	// 写入与重载期间持有 reloadMu，文件监听触发时内容已加载，不会重复重载
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if isOverridden(key) {
+		return nil, fmt.Errorf("%w: %s", ErrOverridden, key)
+	}
+
+	// 以配置文件中的内容为准，包括尚未重载的修改
+	data, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	base, _, err := readConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	field, err := lookupField(reflect.ValueOf(base).Elem(), key)
+	if err != nil {
+		return nil, err
+	}
+	rules, ok := field.Interface().([]T)
+	if !ok {
+		return nil, fmt.Errorf("配置项 %s 的类型应为 %s", key, field.Type())
+	}
+
+	rules, err = mutate(slices.Clone(rules))
+	if err != nil {
+		return nil, err
+	}
+	if data, err = setConfigValue(data, key, rules); err != nil {
+		return nil, err
+	}
+
+	// 校验修改后的整个配置文件，通过后再写入
+	snapshot, warns, err := readConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(ConfigFile, data); err != nil {
+		return nil, err
+	}
+	loadedSum = sha256.Sum256(data)
+	return storeSnapshot(snapshot, warns), nil
+}
+
+// isOverridden 判断配置项是否由环境变量或命令行指定
+func isOverridden(key string) bool {
+	if _, ok := cliOverrides[key]; ok {
+		return true
+	}
+	for _, name := range []string{EnvName(key), EnvName(key) + envFileSuffix} {
+		if _, ok := os.LookupEnv(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupField 按配置项名称查找快照中的字段
+func lookupField(value reflect.Value, key string) (reflect.Value, error) {
+	for _, name := range strings.Split(key, ".") {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("未知的配置项: %s", key)
+		}
+		found := false
+		for i := 0; i < value.NumField(); i++ {
+			if fieldKey(value.Type().Field(i)) == name {
+				value = value.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("未知的配置项: %s", key)
+		}
+	}
+	return value, nil
+}
+
+// setConfigValue 修改配置文件内容中的单个配置项，保留其余内容及注释
+func setConfigValue(data []byte, key string, value interface{}) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	if doc.Kind == 0 {
+		// 空配置文件
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("配置文件格式错误: 顶层应为映射")
+	}
+
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return nil, fmt.Errorf("序列化配置项 %s 失败: %v", key, err)
+	}
+	if err := setNode(root, strings.Split(key, "."), &node); err != nil {
+		return nil, fmt.Errorf("修改配置项 %s 失败: %v", key, err)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("序列化配置文件失败: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("序列化配置文件失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// setNode 在映射节点中设置配置项，中间层级不存在时自动创建
+func setNode(mapping *yaml.Node, path []string, value *yaml.Node) error {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		current := mapping.Content[i+1]
+		if len(path) == 1 {
+			// 保留原配置项上的注释
+			value.HeadComment = current.HeadComment
+			value.LineComment = current.LineComment
+			value.FootComment = current.FootComment
+			mapping.Content[i+1] = value
+			return nil
+		}
+		if current.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s 不是映射", path[0])
+		}
+		return setNode(current, path[1:], value)
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+	if len(path) == 1 {
+		mapping.Content = append(mapping.Content, keyNode, value)
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	mapping.Content = append(mapping.Content, keyNode, child)
+	return setNode(child, path[1:], value)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再替换，避免写入中途失败或被读取到不完整的内容
+// 配置文件为软链接时替换链接指向的文件
+func writeFileAtomic(path string, data []byte) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("设置文件权限失败: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("替换配置文件失败: %v", err)
+	}
+	return nil
+}
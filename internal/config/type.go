@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // 程序版本信息
 type VersionInfo struct {
 	AppVersion string // 程序版本号
@@ -10,73 +12,192 @@ type VersionInfo struct {
 	Arch       string // 架构
 }
 
+// 配置快照，包含全部可热重载的配置项
+type Snapshot struct {
+	Port         int                 `mapstructure:"port"`          // 监听端口
+	Host         string              `mapstructure:"host"`          // 监听地址
+	Shutdown     ShutdownSetting     `mapstructure:"shutdown"`      // 退出配置
+	Server       ServerSetting       `mapstructure:"server"`        // 媒体服务器类型配置
+	PlexServer   PlexServerSetting   `mapstructure:"plex_server"`   // Plex服务器配置
+	Logger       LoggerSetting       `mapstructure:"logger"`        // 日志配置
+	Auth         AuthSetting         `mapstructure:"auth"`          // 接口认证配置
+	ClientFilter ClientFilterSetting `mapstructure:"client_filter"` // 客户端过滤配置
+	Plex302      Plex302Setting      `mapstructure:"plex302"`       // Plex302重定向配置
+	PartIndex    PartIndexSetting    `mapstructure:"part_index"`    // 分段索引配置
+	PathMapping  PathMappingConfig   `mapstructure:"path_mapping"`  // 路径映射配置
+	Symlink      SymlinkConfig       `mapstructure:"symlink"`       // 软链接配置
+	StrmRedirect StrmRedirectConfig  `mapstructure:"strm_redirect"` // STRM重定向配置
+}
+
+// 退出设置
+type ShutdownSetting struct {
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"` // 等待进行中的请求完成的最长时间，0表示一直等待
+}
+
+// 媒体服务器类型设置
+type ServerSetting struct {
+	Type string `mapstructure:"type"` // 服务器类型：plex、jellyfin、emby，地址及令牌仍使用 plex_server 配置
+}
+
 // Plex服务器相关设置
 type PlexServerSetting struct {
-	ADDR string // 地址
-	AUTH string // 认证授权TOKEN
+	ADDR         string             `mapstructure:"addr"`           // 地址
+	AUTH         string             `mapstructure:"auth"`           // 认证授权TOKEN
+	MaxIdleConns int                `mapstructure:"max_idle_conns"` // 连接池最大空闲连接数
+	Timeouts     PlexTimeoutSetting `mapstructure:"timeouts"`       // 超时设置
+
+	Upstreams   []PlexUpstreamSetting  `mapstructure:"upstreams"`    // 备用Plex服务器列表
+	HealthCheck PlexHealthCheckSetting `mapstructure:"health_check"` // 健康检查设置
+	Routes      []PlexRouteRule        `mapstructure:"routes"`       // 路由规则列表
+}
+
+// 备用Plex服务器设置
+type PlexUpstreamSetting struct {
+	Name     string `mapstructure:"name"`     // 名称，用于路由规则及状态展示
+	Addr     string `mapstructure:"addr"`     // 地址
+	Auth     string `mapstructure:"auth"`     // 认证授权TOKEN
+	Priority int    `mapstructure:"priority"` // 优先级，数值越小越优先，主服务器为0
+}
+
+// Plex服务器健康检查设置
+type PlexHealthCheckSetting struct {
+	Enable   bool          `mapstructure:"enable"`   // 启用定时健康检查
+	Interval time.Duration `mapstructure:"interval"` // 检查间隔
+	Timeout  time.Duration `mapstructure:"timeout"`  // 单次检查超时
+}
+
+// Plex路由规则，全部非空条件均满足时命中，命中的服务器不可用时按优先级故障转移
+type PlexRouteRule struct {
+	Upstream   string `mapstructure:"upstream"`    // 目标服务器名称
+	PathPrefix string `mapstructure:"path_prefix"` // 请求路径前缀
+	Section    string `mapstructure:"section"`     // 媒体库ID
+	Client     string `mapstructure:"client"`      // 客户端名称（X-Plex-Product 或 User-Agent 包含该值）
+}
+
+// Plex请求超时设置，0表示不限制
+type PlexTimeoutSetting struct {
+	Dial           time.Duration `mapstructure:"dial"`            // 建立连接超时
+	TLSHandshake   time.Duration `mapstructure:"tls_handshake"`   // TLS握手超时
+	ResponseHeader time.Duration `mapstructure:"response_header"` // 等待响应头超时
+	IdleConn       time.Duration `mapstructure:"idle_conn"`       // 空闲连接保持时长
+	API            time.Duration `mapstructure:"api"`             // 普通API请求的无响应时长限制，响应体持续传输时不中断
+	Media          time.Duration `mapstructure:"media"`           // 媒体文件请求的无响应时长限制
+	Transcode      time.Duration `mapstructure:"transcode"`       // 转码请求的无响应时长限制
 }
 
 // 日志设置
 type LoggerSetting struct {
-	AccessLogger  BaseLoggerSetting // 访问日志相关配置
-	ServiceLogger BaseLoggerSetting // 服务日志相关配置
+	Format        string            `mapstructure:"format"`         // 日志格式：text、json
+	AccessLogger  BaseLoggerSetting `mapstructure:"access_logger"`  // 访问日志相关配置
+	ServiceLogger BaseLoggerSetting `mapstructure:"service_logger"` // 服务日志相关配置
+}
+
+// 接口认证设置，任一方式认证通过即可访问
+type AuthSetting struct {
+	APIKey    string           `mapstructure:"api_key"`    // API密钥
+	Basic     BasicAuthSetting `mapstructure:"basic"`      // HTTP Basic认证
+	PlexOwner bool             `mapstructure:"plex_owner"` // 允许Plex服务器所有者的令牌访问
+}
+
+// Enabled 判断是否配置了任一认证方式
+func (a AuthSetting) Enabled() bool {
+	return a.APIKey != "" || a.Basic.Username != "" || a.PlexOwner
+}
+
+// HTTP Basic认证设置
+type BasicAuthSetting struct {
+	Username string `mapstructure:"username"` // 用户名，为空时不启用
+	Password string `mapstructure:"password"` // 密码
 }
 
 // 基础日志配置字段
 type BaseLoggerSetting struct {
-	Console bool // 是否将日志输出到终端中
-	File    bool // 是否将日志输出到文件中
+	Console bool             `mapstructure:"console"` // 是否将日志输出到终端中
+	File    bool             `mapstructure:"file"`    // 是否将日志输出到文件中
+	Level   string           `mapstructure:"level"`   // 日志级别：debug、info、warn、error
+	Rotate  LogRotateSetting `mapstructure:"rotate"`  // 日志文件切割配置
 }
 
-// 客户端User-Agent过滤设置
+// 日志文件切割设置
+type LogRotateSetting struct {
+	MaxSize    int           `mapstructure:"max_size"`    // 单个日志文件的最大大小（MB），0为不按大小切割
+	Interval   time.Duration `mapstructure:"interval"`    // 按时间切割的间隔，如 24h，0为不按时间切割
+	MaxBackups int           `mapstructure:"max_backups"` // 保留的旧日志文件数量，0为不限制
+	MaxAge     int           `mapstructure:"max_age"`     // 旧日志文件保留天数，0为不限制
+	Compress   bool          `mapstructure:"compress"`    // 是否使用gzip压缩旧日志文件
+}
+
+// 客户端过滤设置
 type ClientFilterSetting struct {
-	Enable  bool     // 启用过滤
-	Mode    string   // 过滤模式：whitelist 或 blacklist
-	Clients []string // 客户端列表
+	Enable         bool               `mapstructure:"enable"`          // 启用过滤
+	Mode           string             `mapstructure:"mode"`            // 旧版过滤模式：allow 或 deny（兼容 whitelist、blacklist），与 client_list 一起转换为规则
+	Clients        []string           `mapstructure:"client_list"`     // 旧版User-Agent列表
+	DefaultAction  string             `mapstructure:"default_action"`  // 未命中任何规则时的动作：allow 或 deny，为空时旧版白名单模式（mode 为 allow 且配置了 client_list）下拒绝，否则允许
+	TrustedProxies []string           `mapstructure:"trusted_proxies"` // 可信代理的IP或CIDR，仅信任来自这些地址的 X-Forwarded-For、X-Real-IP
+	Rules          []ClientFilterRule `mapstructure:"rules"`           // 过滤规则，按顺序匹配，以第一条命中的规则为准
+}
+
+// 客户端过滤规则，规则中配置的各项条件需全部满足，每项条件中的取值满足其一即可，未配置的条件不做限制
+type ClientFilterRule struct {
+	Action     string   `mapstructure:"action"`      // 动作：allow 或 deny
+	CIDRs      []string `mapstructure:"cidrs"`       // 客户端IP所在网段
+	Products   []string `mapstructure:"products"`    // X-Plex-Product 包含其中一项，不区分大小写
+	Platforms  []string `mapstructure:"platforms"`   // X-Plex-Platform 包含其中一项，不区分大小写
+	Devices    []string `mapstructure:"devices"`     // X-Plex-Device 包含其中一项，不区分大小写
+	UserAgents []string `mapstructure:"user_agents"` // User-Agent 包含其中一项，不区分大小写
+	Users      []string `mapstructure:"users"`       // Plex用户名，根据请求携带的令牌查询，不区分大小写
 }
 
 // Plex302重定向设置
 type Plex302Setting struct {
-	Enable            bool     // 启用302重定向功能
-	MediaMountPaths   []string // 媒体挂载路径列表
-	TranscodeEnable   bool     // 是否允许转码
-	FallbackOriginal  bool     // 失败时是否回退到原始链接
-	CheckLinkValidity bool     // 是否检查链接有效性
+	Enable            bool     `mapstructure:"enable"`              // 启用302重定向功能
+	MediaMountPaths   []string `mapstructure:"media_mount_paths"`   // 媒体挂载路径列表
+	TranscodeEnable   bool     `mapstructure:"transcode_enable"`    // 是否允许转码
+	FallbackOriginal  bool     `mapstructure:"fallback_original"`   // 失败时是否回退到原始链接
+	CheckLinkValidity bool     `mapstructure:"check_link_validity"` // 是否检查链接有效性
+}
+
+// 分段索引设置
+type PartIndexSetting struct {
+	Enable          bool          `mapstructure:"enable"`           // 启用分段ID索引缓存
+	TTL             time.Duration `mapstructure:"ttl"`              // 实时查询结果的缓存时长
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"` // 后台刷新媒体库索引的间隔
+	Persist         bool          `mapstructure:"persist"`          // 是否将索引持久化到磁盘
 }
 
 // 路径映射规则
 type PathMappingRule struct {
-	From string // 源路径
-	To   string // 目标路径
+	From string `mapstructure:"from" yaml:"from" json:"from"` // 源路径
+	To   string `mapstructure:"to" yaml:"to" json:"to"`       // 目标路径
 }
 
 // 路径映射配置
 type PathMappingConfig struct {
-	Enable bool               // 启用路径映射
-	Rules  []PathMappingRule  // 映射规则列表
+	Enable bool              `mapstructure:"enable"` // 启用路径映射
+	Rules  []PathMappingRule `mapstructure:"rules"`  // 映射规则列表
 }
 
 // 软链接规则
 type SymlinkRule struct {
-	Path   string // 路径匹配规则
-	Target string // 目标路径
+	Path   string `mapstructure:"path" yaml:"path" json:"path"`       // 路径匹配规则
+	Target string `mapstructure:"target" yaml:"target" json:"target"` // 目标路径
 }
 
 // 软链接配置
 type SymlinkConfig struct {
-	Enable bool          // 启用软链接处理
-	Rules  []SymlinkRule // 软链接规则列表
+	Enable bool          `mapstructure:"enable"` // 启用软链接处理
+	Rules  []SymlinkRule `mapstructure:"rules"`  // 软链接规则列表
 }
 
 // STRM重定向规则
 type StrmRedirectRule struct {
-	MatchType string   // 匹配类型：startswith, endswith, contains, regex
-	Patterns  []string // 匹配模式列表
-	Action    string   // 动作：proxy, redirect
+	MatchType string   `mapstructure:"match_type" yaml:"match_type" json:"match_type"` // 匹配类型：startswith, endswith, contains, regex
+	Patterns  []string `mapstructure:"patterns" yaml:"patterns" json:"patterns"`       // 匹配模式列表
+	Action    string   `mapstructure:"action" yaml:"action" json:"action"`             // 动作：proxy, redirect
 }
 
 // STRM重定向配置
 type StrmRedirectConfig struct {
-	Enable        bool                // 启用STRM重定向
-	LastLinkRules []StrmRedirectRule  // 最终链接处理规则
-}
\ No newline at end of file
+	Enable        bool               `mapstructure:"enable"`          // 启用STRM重定向
+	LastLinkRules []StrmRedirectRule `mapstructure:"last_link_rules"` // 最终链接处理规则
+}
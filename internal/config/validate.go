@@ -0,0 +1,281 @@
+package config
+
+import (
+	"PlexWarp/constants"
+	"PlexWarp/utils"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ValidationError 单个配置项的校验错误
+type ValidationError struct {
+	Key     string `json:"key"`     // 配置项路径，如 strm_redirect.last_link_rules[0].match_type
+	Message string `json:"message"` // 错误描述
+}
+
+// ValidationErrors 配置校验发现的全部问题
+type ValidationErrors []ValidationError
+
+// Error 实现 error 接口，逐行列出全部问题
+func (e ValidationErrors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("配置校验失败，共 %d 个问题:", len(e)))
+	for _, item := range e {
+		lines = append(lines, fmt.Sprintf("  - %s: %s", item.Key, item.Message))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validator 配置校验器，收集全部问题而不是遇到第一个错误就返回
+type validator struct {
+	errs ValidationErrors
+}
+
+// addf 记录一个校验错误
+func (v *validator) addf(key, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// nonNegative 校验时长不为负数
+func (v *validator) nonNegative(key string, d time.Duration) {
+	if d < 0 {
+		v.addf(key, "不能为负数: %s", d)
+	}
+}
+
+// logger 校验单个日志的级别及切割配置
+func (v *validator) logger(key string, setting BaseLoggerSetting) {
+	switch setting.Level {
+	case constants.LOG_LEVEL_DEBUG, constants.LOG_LEVEL_INFO, constants.LOG_LEVEL_WARN, constants.LOG_LEVEL_ERROR:
+	default:
+		v.addf(key+".level", "未知的日志级别 %q，可选值: debug, info, warn, error", setting.Level)
+	}
+
+	rotate := setting.Rotate
+	if rotate.MaxSize < 0 {
+		v.addf(key+".rotate.max_size", "不能为负数: %d", rotate.MaxSize)
+	}
+	v.nonNegative(key+".rotate.interval", rotate.Interval)
+	if rotate.MaxBackups < 0 {
+		v.addf(key+".rotate.max_backups", "不能为负数: %d", rotate.MaxBackups)
+	}
+	if rotate.MaxAge < 0 {
+		v.addf(key+".rotate.max_age", "不能为负数: %d", rotate.MaxAge)
+	}
+}
+
+// filterAction 校验客户端过滤动作
+func (v *validator) filterAction(key, action string) {
+	switch action {
+	case constants.FILTER_MODE_ALLOW, constants.FILTER_MODE_DENY:
+	default:
+		v.addf(key, "未知的动作 %q，可选值: allow, deny", action)
+	}
+}
+
+// cidrs 校验IP地址或CIDR网段列表
+func (v *validator) cidrs(key string, values []string) {
+	for i, value := range values {
+		if _, err := utils.ParseCIDR(value); err != nil {
+			v.addf(fmt.Sprintf("%s[%d]", key, i), "无效的IP地址或网段: %q", value)
+		}
+	}
+}
+
+// validate 校验配置快照，返回 ValidationErrors 或 nil
+func validate(s *Snapshot) error {
+	v := &validator{}
+
+	// 基础配置
+	if s.Port <= 0 || s.Port > 65535 {
+		v.addf("port", "无效的端口: %d", s.Port)
+	}
+	v.nonNegative("shutdown.drain_timeout", s.Shutdown.DrainTimeout)
+
+	// 媒体服务器配置
+	switch constants.PlexServerType(s.Server.Type) {
+	case constants.PlexServerTypePlex, constants.PlexServerTypeJellyfin, constants.PlexServerTypeEmby:
+	default:
+		v.addf("server.type", "未知的服务器类型 %q，可选值: plex, jellyfin, emby", s.Server.Type)
+	}
+
+	// Plex服务器配置
+	if u, err := url.Parse(s.PlexServer.ADDR); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf("plex_server.addr", "无效的地址 %q，应为 http(s)://host:port 形式", s.PlexServer.ADDR)
+	}
+	if s.PlexServer.MaxIdleConns < 0 {
+		v.addf("plex_server.max_idle_conns", "不能为负数: %d", s.PlexServer.MaxIdleConns)
+	}
+	timeouts := s.PlexServer.Timeouts
+	v.nonNegative("plex_server.timeouts.dial", timeouts.Dial)
+	v.nonNegative("plex_server.timeouts.tls_handshake", timeouts.TLSHandshake)
+	v.nonNegative("plex_server.timeouts.response_header", timeouts.ResponseHeader)
+	v.nonNegative("plex_server.timeouts.idle_conn", timeouts.IdleConn)
+	v.nonNegative("plex_server.timeouts.api", timeouts.API)
+	v.nonNegative("plex_server.timeouts.media", timeouts.Media)
+	v.nonNegative("plex_server.timeouts.transcode", timeouts.Transcode)
+
+	// 备用Plex服务器及路由规则
+	upstreamNames := map[string]string{constants.PRIMARY_UPSTREAM: "plex_server.addr"}
+	for i, upstream := range s.PlexServer.Upstreams {
+		key := fmt.Sprintf("plex_server.upstreams[%d]", i)
+		if upstream.Name == "" {
+			v.addf(key+".name", "不能为空")
+		} else if first, ok := upstreamNames[upstream.Name]; ok {
+			v.addf(key+".name", "与 %s 重复: %q", first, upstream.Name)
+		} else {
+			upstreamNames[upstream.Name] = key
+		}
+		if u, err := url.Parse(upstream.Addr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.addf(key+".addr", "无效的地址 %q，应为 http(s)://host:port 形式", upstream.Addr)
+		}
+	}
+	healthCheck := s.PlexServer.HealthCheck
+	if healthCheck.Enable && healthCheck.Interval <= 0 {
+		v.addf("plex_server.health_check.interval", "启用健康检查时应大于0: %s", healthCheck.Interval)
+	}
+	v.nonNegative("plex_server.health_check.timeout", healthCheck.Timeout)
+	for i, route := range s.PlexServer.Routes {
+		key := fmt.Sprintf("plex_server.routes[%d]", i)
+		if _, ok := upstreamNames[route.Upstream]; !ok {
+			v.addf(key+".upstream", "未知的服务器 %q", route.Upstream)
+		}
+		if route.PathPrefix == "" && route.Section == "" && route.Client == "" {
+			v.addf(key, "至少需要指定 path_prefix、section、client 中的一项")
+		}
+	}
+
+	// 接口认证配置
+	if basic := s.Auth.Basic; basic.Username != "" && basic.Password == "" {
+		v.addf("auth.basic.password", "配置用户名时不能为空")
+	} else if basic.Username == "" && basic.Password != "" {
+		v.addf("auth.basic.username", "配置密码时不能为空")
+	}
+	if s.Auth.PlexOwner && constants.PlexServerType(s.Server.Type) != constants.PlexServerTypePlex {
+		v.addf("auth.plex_owner", "仅支持Plex服务器，当前服务器类型为 %q", s.Server.Type)
+	}
+
+	// 日志配置
+	switch s.Logger.Format {
+	case constants.LOG_FORMAT_TEXT, constants.LOG_FORMAT_JSON:
+	default:
+		v.addf("logger.format", "未知的日志格式 %q，可选值: text, json", s.Logger.Format)
+	}
+	v.logger("logger.access_logger", s.Logger.AccessLogger)
+	v.logger("logger.service_logger", s.Logger.ServiceLogger)
+
+	// 客户端过滤配置
+	filter := s.ClientFilter
+	switch filter.Mode {
+	case constants.FILTER_MODE_ALLOW, constants.FILTER_MODE_DENY:
+	default:
+		v.addf("client_filter.mode", "未知的过滤模式 %q，可选值: allow, deny", filter.Mode)
+	}
+	if filter.DefaultAction != "" {
+		v.filterAction("client_filter.default_action", filter.DefaultAction)
+	}
+	v.cidrs("client_filter.trusted_proxies", filter.TrustedProxies)
+	for i, rule := range filter.Rules {
+		key := fmt.Sprintf("client_filter.rules[%d]", i)
+		v.filterAction(key+".action", rule.Action)
+		v.cidrs(key+".cidrs", rule.CIDRs)
+		if len(rule.Users) > 0 && constants.PlexServerType(s.Server.Type) != constants.PlexServerTypePlex {
+			v.addf(key+".users", "仅支持Plex服务器，当前服务器类型为 %q", s.Server.Type)
+		}
+	}
+
+	// Plex302重定向配置
+	for i, mountPath := range s.Plex302.MediaMountPaths {
+		if !path.IsAbs(mountPath) {
+			v.addf(fmt.Sprintf("plex302.media_mount_paths[%d]", i), "应为绝对路径: %q", mountPath)
+		}
+	}
+
+	// 分段索引配置
+	v.nonNegative("part_index.ttl", s.PartIndex.TTL)
+	v.nonNegative("part_index.refresh_interval", s.PartIndex.RefreshInterval)
+
+	// 路径映射及软链接规则
+	seenFrom := make(map[string]int)
+	for i, rule := range s.PathMapping.Rules {
+		key := fmt.Sprintf("path_mapping.rules[%d]", i)
+		if rule.From == "" {
+			v.addf(key+".from", "不能为空")
+		}
+		if rule.To == "" {
+			v.addf(key+".to", "不能为空")
+		}
+		if j, ok := seenFrom[rule.From]; ok && rule.From != "" {
+			v.addf(key+".from", "与 path_mapping.rules[%d] 重复: %q", j, rule.From)
+		} else {
+			seenFrom[rule.From] = i
+		}
+	}
+
+	seenPath := make(map[string]int)
+	for i, rule := range s.Symlink.Rules {
+		key := fmt.Sprintf("symlink.rules[%d]", i)
+		if rule.Path == "" {
+			v.addf(key+".path", "不能为空")
+		}
+		if rule.Target == "" {
+			v.addf(key+".target", "不能为空")
+		}
+		if j, ok := seenPath[rule.Path]; ok && rule.Path != "" {
+			v.addf(key+".path", "与 symlink.rules[%d] 重复: %q", j, rule.Path)
+		} else {
+			seenPath[rule.Path] = i
+		}
+	}
+
+	// STRM最终链接规则
+	seenPattern := make(map[string]string)
+	for i, rule := range s.StrmRedirect.LastLinkRules {
+		key := fmt.Sprintf("strm_redirect.last_link_rules[%d]", i)
+		matchType := strings.ToLower(rule.MatchType)
+		switch matchType {
+		case constants.MATCH_TYPE_STARTSWITH, constants.MATCH_TYPE_ENDSWITH, constants.MATCH_TYPE_CONTAINS, constants.MATCH_TYPE_REGEX:
+		default:
+			v.addf(key+".match_type", "未知的匹配类型 %q，可选值: startswith, endswith, contains, regex", rule.MatchType)
+		}
+
+		switch strings.ToLower(rule.Action) {
+		case constants.STRM_ACTION_PROXY, constants.STRM_ACTION_REDIRECT:
+		default:
+			v.addf(key+".action", "未知的动作 %q，可选值: proxy, redirect", rule.Action)
+		}
+
+		if len(rule.Patterns) == 0 {
+			v.addf(key+".patterns", "不能为空")
+		}
+		for j, pattern := range rule.Patterns {
+			patternKey := fmt.Sprintf("%s.patterns[%d]", key, j)
+			if pattern == "" {
+				v.addf(patternKey, "不能为空")
+				continue
+			}
+			if matchType == constants.MATCH_TYPE_REGEX {
+				if _, err := regexp.Compile(pattern); err != nil {
+					v.addf(patternKey, "无效的正则表达式 %q: %v", pattern, err)
+				}
+			}
+
+			// 相同匹配类型的重复模式永远不会命中后面的规则
+			id := matchType + "\x00" + pattern
+			if first, ok := seenPattern[id]; ok {
+				v.addf(patternKey, "与 %s 重复: %q", first, pattern)
+			} else {
+				seenPattern[id] = patternKey
+			}
+		}
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
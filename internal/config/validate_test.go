@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// loadTestSnapshot 以默认配置加上给定的YAML内容构建配置快照
+func loadTestSnapshot(t *testing.T, content string) *Snapshot {
+	t.Helper()
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(content)); err != nil {
+		t.Fatalf("解析测试配置失败: %v", err)
+	}
+	snapshot, _, err := buildSnapshot(v)
+	if err != nil {
+		t.Fatalf("构建配置快照失败: %v", err)
+	}
+	return snapshot
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		keys   []string // 预期出错的配置项，为空表示校验通过
+	}{
+		{
+			name:   "默认配置",
+			config: "",
+		},
+		{
+			name: "有效的完整配置",
+			config: `
+plex_server:
+  addr: https://plex.example.com:32400
+  upstreams:
+    - name: backup
+      addr: http://10.0.0.2:32400
+  routes:
+    - upstream: backup
+      path_prefix: /library
+client_filter:
+  mode: whitelist
+  trusted_proxies: [10.0.0.0/8, 127.0.0.1]
+  rules:
+    - action: Deny
+      cidrs: [192.168.1.0/24]
+path_mapping:
+  rules:
+    - from: /mnt/a
+      to: /data/a
+strm_redirect:
+  last_link_rules:
+    - match_type: Regex
+      patterns: ['^https://cdn\.']
+      action: proxy
+`,
+		},
+		{
+			name:   "端口超出范围",
+			config: "port: 70000",
+			keys:   []string{"port"},
+		},
+		{
+			name:   "无效的服务器地址及类型",
+			config: "server: {type: kodi}\nplex_server: {addr: 'plex:32400'}",
+			keys:   []string{"server.type", "plex_server.addr"},
+		},
+		{
+			name:   "负数时长",
+			config: "plex_server: {timeouts: {api: -1s}}\npart_index: {ttl: -1h}",
+			keys:   []string{"plex_server.timeouts.api", "part_index.ttl"},
+		},
+		{
+			name: "备用服务器及路由规则",
+			config: `
+plex_server:
+  upstreams:
+    - name: primary
+      addr: http://10.0.0.2:32400
+    - addr: ftp://10.0.0.3
+  routes:
+    - upstream: missing
+`,
+			keys: []string{
+				"plex_server.upstreams[0].name",
+				"plex_server.upstreams[1].name",
+				"plex_server.upstreams[1].addr",
+				"plex_server.routes[0].upstream",
+				"plex_server.routes[0]",
+			},
+		},
+		{
+			name:   "Basic认证缺少密码",
+			config: "auth: {basic: {username: admin}}",
+			keys:   []string{"auth.basic.password"},
+		},
+		{
+			name:   "非Plex服务器不支持所有者认证",
+			config: "server: {type: jellyfin}\nauth: {plex_owner: true}",
+			keys:   []string{"auth.plex_owner"},
+		},
+		{
+			name:   "日志配置",
+			config: "logger: {format: xml, access_logger: {level: trace, rotate: {max_backups: -1}}}",
+			keys:   []string{"logger.format", "logger.access_logger.level", "logger.access_logger.rotate.max_backups"},
+		},
+		{
+			name: "客户端过滤配置",
+			config: `
+client_filter:
+  mode: block
+  default_action: reject
+  trusted_proxies: [10.0.0.0/33]
+  rules:
+    - action: allow
+      cidrs: [not-an-ip]
+`,
+			keys: []string{
+				"client_filter.mode",
+				"client_filter.default_action",
+				"client_filter.trusted_proxies[0]",
+				"client_filter.rules[0].cidrs[0]",
+			},
+		},
+		{
+			name:   "相对挂载路径",
+			config: "plex302: {media_mount_paths: [/mnt, media]}",
+			keys:   []string{"plex302.media_mount_paths[1]"},
+		},
+		{
+			name: "重复的路径映射及软链接规则",
+			config: `
+path_mapping:
+  rules:
+    - {from: /mnt/a, to: /data/a}
+    - {from: /mnt/a, to: /data/b}
+    - {from: /mnt/c}
+symlink:
+  rules:
+    - {path: /mnt/a, target: /data/a}
+    - {target: /data/b}
+`,
+			keys: []string{
+				"path_mapping.rules[1].from",
+				"path_mapping.rules[2].to",
+				"symlink.rules[1].path",
+			},
+		},
+		{
+			name: "最终链接规则",
+			config: `
+strm_redirect:
+  last_link_rules:
+    - match_type: glob
+      patterns: ['*.mkv']
+      action: cache
+    - match_type: regex
+      patterns: ['(', '']
+      action: redirect
+    - match_type: startswith
+      action: proxy
+    - match_type: contains
+      patterns: [cdn, cdn]
+      action: proxy
+`,
+			keys: []string{
+				"strm_redirect.last_link_rules[0].match_type",
+				"strm_redirect.last_link_rules[0].action",
+				"strm_redirect.last_link_rules[1].patterns[0]",
+				"strm_redirect.last_link_rules[1].patterns[1]",
+				"strm_redirect.last_link_rules[2].patterns",
+				"strm_redirect.last_link_rules[3].patterns[1]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(loadTestSnapshot(t, tt.config))
+			if len(tt.keys) == 0 {
+				if err != nil {
+					t.Fatalf("预期校验通过，实际: %v", err)
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("预期返回 ValidationErrors，实际: %v", err)
+			}
+			var keys []string
+			for _, item := range errs {
+				keys = append(keys, item.Key)
+			}
+			slices.Sort(keys)
+			want := slices.Sorted(slices.Values(tt.keys))
+			if !slices.Equal(keys, want) {
+				t.Errorf("出错的配置项为 %q，预期 %q\n%v", keys, want, err)
+			}
+		})
+	}
+}
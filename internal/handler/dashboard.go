@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"PlexWarp/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionsHandler 正在经由PlexWarp传输的媒体流
+func SessionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.ActiveSessions())
+}
+
+// DecisionsHandler 最近的strm重定向决策
+func DecisionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.RecentDecisions())
+}
+
+// StatsHandler 规则命中次数及缓存统计
+func StatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"rule_hits":  service.RuleHits(),
+		"part_index": service.GetPartIndexStats(),
+		"probes":     service.GetProbeStats(),
+	})
+}
@@ -3,23 +3,17 @@ package handler
 import (
 	"PlexWarp/internal/config"
 	"PlexWarp/internal/logging"
+	"PlexWarp/internal/metrics"
 	"PlexWarp/internal/service"
+	"PlexWarp/utils"
+	"errors"
 	"io"
-	"log"
 	"net/http"
-	"regexp"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-var (
-	// 媒体文件路径正则表达式
-	mediaFileRegex = regexp.MustCompile(`/library/parts/(\d+)/(\d+)/file`)
-	// 转码相关路径正则表达式
-	transcodeRegex = regexp.MustCompile(`/video/:/transcode/`)
-)
-
 // Init 初始化处理器
 func Init() error {
 	// 检查Plex连接
@@ -33,50 +27,45 @@ func Init() error {
 
 // ProxyHandler Plex代理处理器
 func ProxyHandler(c *gin.Context) {
-	// 检查是否需要进行strm重定向
-	if shouldHandleStrmRedirect(c.Request) {
-		if handleStrmRedirect(c.Writer, c.Request) {
-			return // 重定向成功，直接返回
-		}
-		// 重定向失败，继续正常代理流程
+	// 播放请求检查是否需要进行strm重定向
+	if handleStrmRedirect(c.Writer, c.Request) {
+		return // 重定向成功，直接返回
 	}
+	// 非播放请求或重定向失败，继续正常代理流程
 
-	// 获取请求路径
-	path := c.Request.URL.Path
+	// 获取请求路径，保留原始转义形式
+	path := c.Request.URL.EscapedPath()
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
 
-	// 获取查询参数
-	params := make(map[string]string)
-	for key, values := range c.Request.URL.Query() {
-		if len(values) > 0 {
-			params[key] = values[0]
-		}
-	}
-
-	// 获取请求头
-	headers := make(map[string]string)
+	// 获取请求头，保留重复的请求头
+	headers := make(http.Header, len(c.Request.Header))
 	for key, values := range c.Request.Header {
-		if len(values) > 0 && !isHopByHopHeader(key) {
-			headers[key] = values[0]
+		if !utils.IsHopByHopHeader(key) {
+			headers[key] = values
 		}
 	}
 
+	// 按路由类别设置超时，媒体流和转码请求默认不限制总时长
+	ctx, cancel := service.RouteContext(c.Request.Context(), c.Request.URL.Path)
+	defer cancel()
+
 	// 代理请求到Plex服务器
-	resp, err := service.ProxyRequest(c.Request.Method, path, params, headers)
+	resp, err := service.ForwardRequest(ctx, c.Request.Method, path, c.Request.URL.Query(), headers, c.Request.Body, c.Request.ContentLength)
 	if err != nil {
-		logging.Errorf("代理请求失败: %v", err)
+		logging.Ctx(c.Request.Context()).Errorf("代理请求失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "代理请求失败"})
 		return
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头
+	// 复制响应头，以上游的取值替换中间件设置的同名响应头，保留重复的响应头
 	for key, values := range resp.Header {
-		if !isHopByHopHeader(key) {
+		if !utils.IsHopByHopHeader(key) {
+			c.Writer.Header().Del(key)
 			for _, value := range values {
-				c.Header(key, value)
+				c.Writer.Header().Add(key, value)
 			}
 		}
 	}
@@ -84,13 +73,31 @@ func ProxyHandler(c *gin.Context) {
 	// 设置状态码
 	c.Status(resp.StatusCode)
 
-	// 复制响应体
-	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
-		logging.Errorf("复制响应体失败: %v", err)
+	// 播放及转码请求在传输期间作为会话展示
+	var writer http.ResponseWriter = c.Writer
+	if isPlaybackRequest(c.Request) {
+		var done func()
+		writer, done = service.TrackSession(c.Writer, c.Request, metrics.SourcePlex)
+		defer done()
+	}
+
+	// HEAD 请求及无响应体的状态码只写入响应头，保留上游的 Content-Length
+	if !responseHasBody(c.Request.Method, resp.StatusCode) {
+		c.Writer.WriteHeaderNow()
+	} else if err := copyResponseBody(writer, resp.Body, isEventStream(resp)); err != nil {
+		if c.Request.Context().Err() != nil {
+			// 客户端主动断开（如拖动进度条），属于正常情况
+			logging.Ctx(c.Request.Context()).Debugf("客户端已断开: %s %v", c.Request.URL.Path, err)
+		} else {
+			logging.Ctx(c.Request.Context()).Errorf("复制响应体失败: %v", err)
+		}
+	}
+	if size := c.Writer.Size(); size > 0 {
+		metrics.BytesStreamed.WithLabelValues(metrics.SourcePlex).Add(float64(size))
 	}
 
 	// 记录访问日志
-	logging.AccessInfof("%s %s %d", c.Request.Method, c.Request.URL.Path, resp.StatusCode)
+	logging.AccessCtx(c.Request.Context()).Infof("%s %s %d", c.Request.Method, c.Request.URL.Path, resp.StatusCode)
 }
 
 // HealthHandler 健康检查处理器
@@ -102,112 +109,138 @@ func HealthHandler(c *gin.Context) {
 	})
 }
 
+// ProbeHandler 链接有效性探测记录处理器
+func ProbeHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enable": config.Current().Plex302.CheckLinkValidity,
+		"probes": service.RecentProbes(),
+	})
+}
+
+// UpstreamsHandler Plex服务器状态处理器
+func UpstreamsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.UpstreamStatuses())
+}
+
+// ConfigHandler 当前生效配置处理器，敏感配置项已隐藏取值
+func ConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Effective())
+}
+
 // VersionHandler 版本信息处理器
 func VersionHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, config.Version())
 }
 
-// shouldHandleStrmRedirect 检查是否需要处理strm重定向
-func shouldHandleStrmRedirect(r *http.Request) bool {
-	// 检查是否为媒体文件请求或转码请求
-	return mediaFileRegex.MatchString(r.URL.Path) || transcodeRegex.MatchString(r.URL.Path)
-}
-
 // handleStrmRedirect 处理strm重定向
 func handleStrmRedirect(w http.ResponseWriter, r *http.Request) bool {
 	// 检查plex302功能是否启用
-	if !config.Plex302.Enable {
+	if !config.Current().Plex302.Enable {
+		return false
+	}
+
+	// 检查是否为播放请求
+	backend := service.CurrentBackend()
+	mediaRequest := backend.ParseMediaRequest(r.URL.Path, r.URL.Query())
+	if mediaRequest == nil {
+		return false
+	}
+
+	// 禁用转码重定向时无需查询文件路径
+	if !config.Current().Plex302.TranscodeEnable && backend.IsTranscodePath(r.URL.Path) {
 		return false
 	}
 
-	log.Printf("处理strm重定向请求: %s", r.URL.Path)
+	logger := logging.Ctx(r.Context())
+	logger.Infof("处理strm重定向请求: %s", r.URL.Path)
 	
 	// 创建strm服务实例
 	strmService := service.NewStrmService()
 	
-	// 检查是否应该进行重定向
-	userAgent := r.Header.Get("User-Agent")
-	if !strmService.ShouldRedirect(r.URL.Path, userAgent) {
-		return false
-	}
-	
-	// 尝试从请求路径中提取文件路径信息
-	filePath := extractFilePathFromRequest(r)
-	if filePath == "" {
-		log.Printf("无法从请求中提取文件路径: %s", r.URL.Path)
+	// 从请求中解析媒体文件的实际路径
+	filePath, err := backend.LookupFile(r.Context(), mediaRequest)
+	if err != nil {
+		// 照片等未检索的分段找不到文件属于正常情况
+		if errors.Is(err, service.ErrFileNotFound) {
+			logger.Debugf("无法从请求中提取文件路径: %s, %v", r.URL.Path, err)
+		} else {
+			logger.Warnf("无法从请求中提取文件路径: %s, %v", r.URL.Path, err)
+		}
 		return false
 	}
 	
-	// 检查是否为strm文件
-	if !strmService.IsStrmFile(filePath) {
+	// 检查是否应该进行重定向
+	if !strmService.ShouldRedirect(filePath, r.URL.Path) {
 		return false
 	}
 	
 	// 尝试处理重定向
-	err := strmService.HandleRedirect(w, r, filePath)
+	err = strmService.HandleRedirect(w, r, filePath)
 	if err != nil {
-		log.Printf("strm重定向失败: %v", err)
+		logger.Warnf("strm重定向失败: %v", err)
 		
-		// 如果错误信息包含"fallback"，表示需要回退到原始请求
-		if strings.Contains(err.Error(), "fallback") {
+		// 文件可能已被移动或删除，使缓存的查询结果失效以便下次重新查询
+		backend.Invalidate(mediaRequest)
+		
+		// 需要回退到原始请求
+		if errors.Is(err, service.ErrFallback) {
 			return false
 		}
 		
+		// strm指向不允许的本地文件
+		if errors.Is(err, service.ErrForbiddenPath) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return true
+		}
+
 		// 其他错误，返回错误响应
 		http.Error(w, "Strm redirect failed", http.StatusInternalServerError)
 		return true
 	}
 	
-	log.Printf("strm重定向成功: %s", filePath)
+	logger.Infof("strm重定向成功: %s", filePath)
 	return true
 }
 
-// extractFilePathFromRequest 从请求中提取文件路径
-func extractFilePathFromRequest(r *http.Request) string {
-	// 这里需要根据Plex的API结构来解析文件路径
-	// 由于我们无法直接从URL路径获取完整的文件路径，
-	// 这里返回一个示例实现，实际使用时需要根据具体情况调整
-	
-	// 对于媒体文件请求，通常需要查询Plex数据库或API来获取实际文件路径
-	// 这里提供一个简化的实现
-	path := r.URL.Path
-	
-	// 如果是媒体文件请求，尝试从查询参数中获取路径信息
-	if mediaFileRegex.MatchString(path) {
-		// 从查询参数中获取文件路径（如果有的话）
-		if filePath := r.URL.Query().Get("path"); filePath != "" {
-			return filePath
-		}
-		
-		// 或者从其他参数中获取
-		if mediaPath := r.URL.Query().Get("file"); mediaPath != "" {
-			return mediaPath
-		}
-	}
-	
-	// 如果无法从查询参数获取，返回空字符串
-	// 实际实现中，这里应该查询Plex数据库来获取文件路径
-	return ""
+// isPlaybackRequest 判断是否为播放或转码请求，不包括通知流等长连接
+func isPlaybackRequest(r *http.Request) bool {
+	backend := service.CurrentBackend()
+	return backend.ParseMediaRequest(r.URL.Path, r.URL.Query()) != nil || backend.IsTranscodePath(r.URL.Path)
 }
 
-// isHopByHopHeader 检查是否为逐跳头部
-func isHopByHopHeader(header string) bool {
-	hopByHopHeaders := []string{
-		"Connection",
-		"Keep-Alive",
-		"Proxy-Authenticate",
-		"Proxy-Authorization",
-		"Te",
-		"Trailers",
-		"Transfer-Encoding",
-		"Upgrade",
-	}
-
-	header = strings.ToLower(header)
-	for _, h := range hopByHopHeaders {
-		if strings.ToLower(h) == header {
-			return true
+// responseHasBody 检查响应是否应包含响应体
+func responseHasBody(method string, status int) bool {
+	return method != http.MethodHead && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// isEventStream 检查响应是否为SSE事件流
+func isEventStream(resp *http.Response) bool {
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	return strings.EqualFold(mediaType, "text/event-stream")
+}
+
+// copyResponseBody 复制响应体，flush 为 true 时每次写入后立即刷新
+func copyResponseBody(w http.ResponseWriter, body io.Reader, flush bool) error {
+	flusher, ok := w.(http.Flusher)
+	if !flush || !ok {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			flusher.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
-	return false
-}
\ No newline at end of file
+}
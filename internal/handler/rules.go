@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// errBadRequest 请求参数错误
+	errBadRequest = errors.New("请求参数错误")
+	// errRuleIndex 规则序号超出范围
+	errRuleIndex = errors.New("规则序号超出范围")
+)
+
+// ruleSet 可通过管理接口修改的规则列表
+type ruleSet interface {
+	// list 获取当前生效的规则列表
+	list() interface{}
+	// insert 在指定位置插入规则，index 为-1时追加到末尾
+	insert(c *gin.Context, index int) ([]string, error)
+	// replace 替换指定位置的规则
+	replace(c *gin.Context, index int) ([]string, error)
+	// move 将规则移动到新位置
+	move(from, to int) ([]string, error)
+	// remove 删除指定位置的规则
+	remove(index int) ([]string, error)
+}
+
+// ruleList 某一类规则的列表
+type ruleList[T any] struct {
+	key string                     // 规则列表的配置项
+	get func(*config.Snapshot) []T // 从配置快照中获取规则列表
+}
+
+// 可修改的规则列表，按接口中的名称索引
+var ruleSets = map[string]ruleSet{
+	"path_mapping": ruleList[config.PathMappingRule]{
+		key: "path_mapping.rules",
+		get: func(s *config.Snapshot) []config.PathMappingRule { return s.PathMapping.Rules },
+	},
+	"symlink": ruleList[config.SymlinkRule]{
+		key: "symlink.rules",
+		get: func(s *config.Snapshot) []config.SymlinkRule { return s.Symlink.Rules },
+	},
+	"last_link": ruleList[config.StrmRedirectRule]{
+		key: "strm_redirect.last_link_rules",
+		get: func(s *config.Snapshot) []config.StrmRedirectRule { return s.StrmRedirect.LastLinkRules },
+	},
+}
+
+// current 获取当前规则列表的副本，修改副本不影响配置快照
+func (l ruleList[T]) current() []T {
+	return append([]T{}, l.get(config.Current())...)
+}
+
+func (l ruleList[T]) list() interface{} {
+	return l.current()
+}
+
+func (l ruleList[T]) insert(c *gin.Context, index int) ([]string, error) {
+	var rule T
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return config.UpdateRules(l.key, func(rules []T) ([]T, error) {
+		if index == -1 {
+			index = len(rules)
+		}
+		if index < 0 || index > len(rules) {
+			return nil, errRuleIndex
+		}
+		return slices.Insert(rules, index, rule), nil
+	})
+}
+
+func (l ruleList[T]) replace(c *gin.Context, index int) ([]string, error) {
+	var rule T
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return config.UpdateRules(l.key, func(rules []T) ([]T, error) {
+		if index < 0 || index >= len(rules) {
+			return nil, errRuleIndex
+		}
+		rules[index] = rule
+		return rules, nil
+	})
+}
+
+func (l ruleList[T]) move(from, to int) ([]string, error) {
+	return config.UpdateRules(l.key, func(rules []T) ([]T, error) {
+		if from < 0 || from >= len(rules) || to < 0 || to >= len(rules) {
+			return nil, errRuleIndex
+		}
+		rule := rules[from]
+		rules = slices.Delete(rules, from, from+1)
+		return slices.Insert(rules, to, rule), nil
+	})
+}
+
+func (l ruleList[T]) remove(index int) ([]string, error) {
+	return config.UpdateRules(l.key, func(rules []T) ([]T, error) {
+		if index < 0 || index >= len(rules) {
+			return nil, errRuleIndex
+		}
+		return slices.Delete(rules, index, index+1), nil
+	})
+}
+
+// RulesHandler 获取全部可修改的规则列表
+func RulesHandler(c *gin.Context) {
+	result := make(gin.H, len(ruleSets))
+	for name, set := range ruleSets {
+		result[name] = set.list()
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// RuleListHandler 获取指定的规则列表
+func RuleListHandler(c *gin.Context) {
+	set, ok := lookupRuleSet(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": set.list()})
+}
+
+// AddRuleHandler 添加规则，可通过 index 参数指定插入位置，默认追加到末尾
+func AddRuleHandler(c *gin.Context) {
+	set, ok := lookupRuleSet(c)
+	if !ok {
+		return
+	}
+	index := -1
+	if value := c.Query("index"); value != "" {
+		var err error
+		if index, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的规则序号: " + value})
+			return
+		}
+	}
+	changes, err := set.insert(c, index)
+	respondRuleUpdate(c, set, changes, err)
+}
+
+// UpdateRuleHandler 替换指定位置的规则
+func UpdateRuleHandler(c *gin.Context) {
+	set, ok := lookupRuleSet(c)
+	if !ok {
+		return
+	}
+	index, ok := ruleIndex(c)
+	if !ok {
+		return
+	}
+	changes, err := set.replace(c, index)
+	respondRuleUpdate(c, set, changes, err)
+}
+
+// MoveRuleHandler 调整规则顺序，请求体为 {"from": 2, "to": 0}
+func MoveRuleHandler(c *gin.Context) {
+	set, ok := lookupRuleSet(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		From *int `json:"from"`
+		To   *int `json:"to"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.From == nil || req.To == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `请求体应为 {"from": 序号, "to": 序号}`})
+		return
+	}
+	changes, err := set.move(*req.From, *req.To)
+	respondRuleUpdate(c, set, changes, err)
+}
+
+// DeleteRuleHandler 删除指定位置的规则
+func DeleteRuleHandler(c *gin.Context) {
+	set, ok := lookupRuleSet(c)
+	if !ok {
+		return
+	}
+	index, ok := ruleIndex(c)
+	if !ok {
+		return
+	}
+	changes, err := set.remove(index)
+	respondRuleUpdate(c, set, changes, err)
+}
+
+// TestRulesHandler 使用当前规则测试路径映射及最终链接规则，请求体为 {"path": "...", "link": "..."}
+func TestRulesHandler(c *gin.Context) {
+	var req struct {
+		Path string `json:"path"`
+		Link string `json:"link"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Path == "" && req.Link == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `请求体应为 {"path": "路径", "link": "链接"}，至少指定一项`})
+		return
+	}
+
+	result := gin.H{}
+	if req.Path != "" {
+		result["mapping"] = service.NewStrmService().MapPath(req.Path)
+	}
+	if req.Link != "" {
+		action, match := service.LinkAction(req.Link)
+		result["link"] = gin.H{"action": action, "rule": match}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// lookupRuleSet 获取请求路径中指定的规则列表，不存在时返回404
+func lookupRuleSet(c *gin.Context) (ruleSet, bool) {
+	set, ok := ruleSets[c.Param("set")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未知的规则列表: " + c.Param("set") + "，可选值: path_mapping, symlink, last_link"})
+	}
+	return set, ok
+}
+
+// ruleIndex 获取请求路径中的规则序号
+func ruleIndex(c *gin.Context) (int, bool) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的规则序号: " + c.Param("index")})
+		return 0, false
+	}
+	return index, true
+}
+
+// respondRuleUpdate 返回规则修改结果，成功时返回修改后的规则列表及配置变化
+func respondRuleUpdate(c *gin.Context, set ruleSet, changes []string, err error) {
+	var validationErrs config.ValidationErrors
+	switch {
+	case err == nil:
+		logging.Ctx(c.Request.Context()).Infof("管理接口修改规则: %s %s", c.Request.Method, c.Request.URL.Path)
+		if changes == nil {
+			changes = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"rules": set.list(), "changes": changes})
+	case errors.Is(err, errBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errRuleIndex):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.As(err, &validationErrs):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配置校验失败", "problems": validationErrs})
+	case errors.Is(err, config.ErrOverridden):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logging.Ctx(c.Request.Context()).Errorf("修改规则失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/service"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpgradeHandler 协议升级处理器，将 WebSocket 等升级请求双向转发到Plex服务器
+// 非升级请求交由后续处理器处理
+func UpgradeHandler(c *gin.Context) {
+	if !isUpgradeRequest(c.Request) {
+		c.Next()
+		return
+	}
+	c.Abort()
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			target, err := url.Parse(service.PlexRequestURL(pr.In.URL.EscapedPath(), pr.In.URL.Query(), pr.In.Header))
+			if err != nil {
+				logging.Errorf("解析URL失败: %v", err)
+				return
+			}
+			pr.Out.URL = target
+			pr.Out.Host = ""
+		},
+		Transport: service.PlexClient.Transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logging.Errorf("升级请求代理失败: %s, %v", r.URL.Path, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	logging.Debugf("代理升级请求: %s %s", c.Request.Header.Get("Upgrade"), c.Request.URL.Path)
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// isUpgradeRequest 检查是否为协议升级请求
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
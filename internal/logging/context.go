@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"context"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDField 日志中请求ID的字段名
+const RequestIDField = "request_id"
+
+type requestIDKey struct{}
+
+// 日志未初始化时使用，丢弃全部日志
+var discardLogger = &logrus.Logger{Out: io.Discard, Formatter: new(logrus.TextFormatter), Hooks: make(logrus.LevelHooks), Level: logrus.PanicLevel}
+
+// WithRequestID 将请求ID保存到上下文中，随后通过 Ctx 输出的日志均带有该ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 获取上下文中的请求ID，不在请求中时返回空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Ctx 获取带有请求ID的服务日志记录器
+func Ctx(ctx context.Context) *logrus.Entry {
+	return withRequestID(ServiceLogger, ctx)
+}
+
+// AccessCtx 获取带有请求ID的访问日志记录器
+func AccessCtx(ctx context.Context) *logrus.Entry {
+	return withRequestID(AccessLogger, ctx)
+}
+
+func withRequestID(logger *logrus.Logger, ctx context.Context) *logrus.Entry {
+	if logger == nil {
+		logger = discardLogger
+	}
+	if id := RequestID(ctx); id != "" {
+		return logger.WithField(RequestIDField, id)
+	}
+	return logrus.NewEntry(logger)
+}
@@ -1,42 +1,78 @@
 package logging
 
 import (
+	"PlexWarp/constants"
 	"PlexWarp/internal/config"
+	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
 	ServiceLogger *logrus.Logger
 	AccessLogger  *logrus.Logger
+
+	// 已打开的日志文件，退出时关闭
+	logFiles []*lumberjack.Logger
+	// 关闭时停止按时间切割日志
+	stopRotate chan struct{}
 )
 
-// Init 初始化日志
-func Init() {
+// 不按大小切割时使用的文件大小上限（MB），lumberjack 的 MaxSize 为0时会使用默认的100MB
+const noSizeLimit = 1 << 30
+
+// Init 初始化日志，日志文件无法打开时返回错误
+func Init() error {
+	setting := config.Current().Logger
+	stopRotate = make(chan struct{})
+
 	// 初始化服务日志
 	ServiceLogger = logrus.New()
-	ServiceLogger.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+	ServiceLogger.SetFormatter(newFormatter(setting.Format))
 
 	// 初始化访问日志
 	AccessLogger = logrus.New()
-	AccessLogger.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+	AccessLogger.SetFormatter(newFormatter(setting.Format))
 
 	// 设置服务日志输出
-	setupLogger(ServiceLogger, "service.log", config.Logger.ServiceLogger)
+	if err := setupLogger(ServiceLogger, "service.log", setting.ServiceLogger); err != nil {
+		return err
+	}
 
 	// 设置访问日志输出
-	setupLogger(AccessLogger, "access.log", config.Logger.AccessLogger)
+	return setupLogger(AccessLogger, "access.log", setting.AccessLogger)
+}
+
+// newFormatter 根据 logger.format 配置创建日志格式
+func newFormatter(format string) logrus.Formatter {
+	if format == constants.LOG_FORMAT_JSON {
+		return &logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+			FieldMap: logrus.FieldMap{
+				logrus.FieldKeyTime: "time",
+				logrus.FieldKeyMsg:  "msg",
+			},
+		}
+	}
+	return &logrus.TextFormatter{
+		FullTimestamp: true,
+	}
 }
 
-// setupLogger 设置日志输出
-func setupLogger(logger *logrus.Logger, filename string, setting config.BaseLoggerSetting) {
+// setupLogger 设置日志级别及输出
+func setupLogger(logger *logrus.Logger, filename string, setting config.BaseLoggerSetting) error {
+	level, err := logrus.ParseLevel(setting.Level)
+	if err != nil {
+		return fmt.Errorf("无效的日志级别: %s", setting.Level)
+	}
+	logger.SetLevel(level)
+
 	var writers []io.Writer
 
 	// 控制台输出
@@ -46,16 +82,102 @@ func setupLogger(logger *logrus.Logger, filename string, setting config.BaseLogg
 
 	// 文件输出
 	if setting.File {
-		logFile := filepath.Join(config.LogDir, filename)
-		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err == nil {
-			writers = append(writers, file)
+		file, err := openLogFile(filepath.Join(config.LogDir, filename), setting.Rotate)
+		if err != nil {
+			return err
 		}
+		writers = append(writers, file)
 	}
 
 	if len(writers) > 0 {
 		logger.SetOutput(io.MultiWriter(writers...))
 	}
+	return nil
+}
+
+// openLogFile 打开支持切割的日志文件
+func openLogFile(path string, rotate config.LogRotateSetting) (*lumberjack.Logger, error) {
+	// lumberjack 在首次写入时才打开文件，提前检查以便启动时发现权限等问题
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("打开日志文件失败: %v", err)
+	}
+	f.Close()
+
+	maxSize := rotate.MaxSize
+	if maxSize == 0 {
+		maxSize = noSizeLimit
+	}
+	file := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSize,
+		MaxBackups: rotate.MaxBackups,
+		MaxAge:     rotate.MaxAge,
+		Compress:   rotate.Compress,
+		LocalTime:  true,
+	}
+	logFiles = append(logFiles, file)
+
+	if rotate.Interval > 0 {
+		go rotateEvery(file, rotate.Interval, stopRotate)
+	}
+	return file, nil
+}
+
+// rotateEvery 按固定间隔切割日志文件
+func rotateEvery(file *lumberjack.Logger, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 没有新日志时不切割，避免产生空文件
+			if info, err := os.Stat(file.Filename); err == nil && info.Size() == 0 {
+				continue
+			}
+			if err := file.Rotate(); err != nil {
+				Warnf("切割日志文件失败: %s, %v", file.Filename, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Reopen 关闭日志文件，下次写入时按原路径重新打开
+// 用于外部 logrotate 等工具移动日志文件后，将日志写入新文件
+func Reopen() {
+	for _, file := range logFiles {
+		if err := file.Close(); err != nil {
+			Warnf("关闭日志文件失败: %s, %v", file.Filename, err)
+		}
+	}
+	Info("日志文件已重新打开")
+}
+
+// Close 关闭日志文件，日志随后只输出到终端
+func Close() {
+	for _, logger := range []*logrus.Logger{ServiceLogger, AccessLogger} {
+		if logger != nil {
+			logger.SetOutput(os.Stdout)
+		}
+	}
+	if stopRotate != nil {
+		close(stopRotate)
+		stopRotate = nil
+	}
+	for _, file := range logFiles {
+		file.Close()
+	}
+	logFiles = nil
+}
+
+// ErrorLog 获取写入服务日志的标准库日志记录器，用于 http.Server 等只支持标准库日志的组件
+func ErrorLog() *log.Logger {
+	if ServiceLogger == nil {
+		return log.New(io.Discard, "", 0)
+	}
+	return log.New(ServiceLogger.WriterLevel(logrus.WarnLevel), "", 0)
 }
 
 // SetLevel 设置日志级别
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 指标名称前缀
+const namespace = "plexwarp"
+
+// 媒体数据来源
+const (
+	SourcePlex   = "plex"   // 代理媒体服务器的响应
+	SourceDirect = "direct" // 代理strm中的直链
+	SourceLocal  = "local"  // 直接提供本地文件
+)
+
+// 请求耗时分桶，覆盖普通API请求到长时间的媒体流（5ms ~ 约22分钟）
+var durationBuckets = prometheus.ExponentialBuckets(0.005, 4, 10)
+
+var (
+	// Requests 客户端请求数
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "客户端请求数，按路由类别、方法及状态码统计",
+	}, []string{"route_class", "method", "status"})
+
+	// RequestDuration 客户端请求耗时
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "客户端请求耗时，媒体流请求包含完整的传输时间",
+		Buckets:   durationBuckets,
+	}, []string{"route_class"})
+
+	// RedirectDecisions strm重定向决策数
+	RedirectDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirect_decisions_total",
+		Help:      "strm重定向决策数，按最终动作统计",
+	}, []string{"action"})
+
+	// StrmReadFailures strm文件读取失败数
+	StrmReadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "strm_read_failures_total",
+		Help:      "strm文件不存在、无法读取或内容为空的次数",
+	})
+
+	// PartLookups 分段索引查询数
+	PartLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "part_lookups_total",
+		Help:      "分段ID到文件路径的查询数，result 为 hit 或 miss",
+	}, []string{"result"})
+
+	// UpstreamDuration 媒体服务器响应耗时
+	UpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_response_duration_seconds",
+		Help:      "从发送请求到收到媒体服务器响应头的耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream", "route_class"})
+
+	// UpstreamErrors 媒体服务器请求失败数
+	UpstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "媒体服务器连接失败数，不包括客户端取消的请求",
+	}, []string{"upstream"})
+
+	// UpstreamUp 媒体服务器可用状态
+	UpstreamUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_up",
+		Help:      "媒体服务器是否可用，1为可用",
+	}, []string{"upstream"})
+
+	// BytesStreamed 传输的媒体数据量
+	BytesStreamed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_streamed_total",
+		Help:      "写入客户端的响应体字节数，按数据来源统计",
+	}, []string{"source"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests,
+		RequestDuration,
+		RedirectDecisions,
+		StrmReadFailures,
+		PartLookups,
+		UpstreamDuration,
+		UpstreamErrors,
+		UpstreamUp,
+		BytesStreamed,
+	)
+}
+
+// Handler Prometheus指标接口处理器
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/service"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// APIKeyHeader 携带API密钥的请求头
+	APIKeyHeader = "X-Api-Key"
+	// AuthMethodKey 认证通过后在请求上下文中记录认证方式的键
+	AuthMethodKey = "auth_method"
+)
+
+// 认证方式
+const (
+	AuthMethodAPIKey    = "api_key"
+	AuthMethodBasic     = "basic"
+	AuthMethodPlexOwner = "plex_owner"
+)
+
+// Auth 接口认证中间件，任一已配置的认证方式通过即可访问：
+//   - API密钥：通过 X-Api-Key 请求头或 Authorization: Bearer 携带 auth.api_key
+//   - HTTP Basic认证：auth.basic 配置的用户名及密码
+//   - Plex服务器所有者令牌：启用 auth.plex_owner 时通过 X-Plex-Token 请求头或查询参数携带
+//
+// 未配置任何认证方式时不做认证，需要保护的接口应同时使用 RequireAuth
+func Auth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setting := config.Current().Auth
+		if !setting.Enabled() {
+			c.Next()
+			return
+		}
+
+		method, err := authenticate(c.Request, setting)
+		if err != nil {
+			logging.Ctx(c.Request.Context()).Warnf("接口认证失败: %s %s: %v", c.ClientIP(), c.Request.URL.Path, err)
+			if errors.Is(err, errUnauthorized) || errors.Is(err, service.ErrNotOwner) {
+				if setting.Basic.Username != "" {
+					c.Header("WWW-Authenticate", `Basic realm="PlexWarp", charset="UTF-8"`)
+				}
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "认证失败"})
+			} else {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "暂时无法校验Plex令牌"})
+			}
+			return
+		}
+		c.Set(AuthMethodKey, method)
+		c.Next()
+	}
+}
+
+// RequireAuth 受保护接口的中间件，需在 Auth 之后使用，未配置任何认证方式时接口不可用
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.Current().Auth.Enabled() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "接口未启用，请先在 auth 中配置认证方式"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// errUnauthorized 请求未携带有效的认证信息
+var errUnauthorized = errors.New("未携带有效的认证信息")
+
+// authenticate 依次尝试已配置的认证方式，返回认证通过的方式
+func authenticate(r *http.Request, setting config.AuthSetting) (string, error) {
+	if setting.APIKey != "" && secureEqual(requestAPIKey(r), setting.APIKey) {
+		return AuthMethodAPIKey, nil
+	}
+
+	if basic := setting.Basic; basic.Username != "" {
+		if username, password, ok := r.BasicAuth(); ok {
+			// 两项均比较，避免通过响应时间判断用户名是否正确
+			usernameOK := secureEqual(username, basic.Username)
+			passwordOK := secureEqual(password, basic.Password)
+			if usernameOK && passwordOK {
+				return AuthMethodBasic, nil
+			}
+		}
+	}
+
+	if setting.PlexOwner {
+		if token := requestPlexToken(r); token != "" {
+			if err := service.CheckOwnerToken(r.Context(), token); err != nil {
+				return "", err
+			}
+			return AuthMethodPlexOwner, nil
+		}
+	}
+	return "", errUnauthorized
+}
+
+// requestAPIKey 获取请求携带的API密钥
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// requestPlexToken 获取请求携带的Plex令牌
+func requestPlexToken(r *http.Request) string {
+	if token := r.Header.Get("X-Plex-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("X-Plex-Token")
+}
+
+// secureEqual 以固定耗时比较密钥，避免通过响应时间猜测密钥
+func secureEqual(got, want string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
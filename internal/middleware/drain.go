@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	draining       atomic.Bool  // 是否正在退出
+	activeRequests atomic.Int64 // 进行中的请求数
+)
+
+// StartDraining 开始退出，此后的新请求返回503，进行中的请求不受影响
+func StartDraining() {
+	draining.Store(true)
+}
+
+// ActiveRequests 获取进行中的请求数
+func ActiveRequests() int64 {
+	return activeRequests.Load()
+}
+
+// Drain 退出控制中间件，统计进行中的请求，退出期间拒绝新请求
+func Drain() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() {
+			c.Header("Connection", "close")
+			c.Header("Retry-After", "30")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+			return
+		}
+
+		activeRequests.Add(1)
+		defer activeRequests.Add(-1)
+		c.Next()
+	}
+}
@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"PlexWarp/constants"
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/service"
+	"PlexWarp/utils"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientFilter 根据配置快照编译的客户端过滤规则
+type clientFilter struct {
+	snapshot      *config.Snapshot // 编译规则时的配置快照
+	rules         []filterRule
+	defaultAction string
+	trusted       []*net.IPNet // 可信代理
+}
+
+// filterRule 编译后的过滤规则，字符串条件均已转换为小写
+type filterRule struct {
+	name       string // 规则名称，用于日志
+	action     string
+	nets       []*net.IPNet
+	products   []string
+	platforms  []string
+	devices    []string
+	userAgents []string
+	users      []string
+}
+
+// filterRequest 待过滤的请求，Plex用户名在需要时才查询
+type filterRequest struct {
+	r        *http.Request
+	ip       net.IP
+	user     string
+	resolved bool
+}
+
+var currentFilter atomic.Pointer[clientFilter]
+
+// ClientFilter 客户端过滤中间件，按顺序匹配 client_filter.rules，以第一条命中的规则为准
+func ClientFilter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.Current().ClientFilter.Enable {
+			c.Next()
+			return
+		}
+
+		filter := loadClientFilter()
+		req := &filterRequest{r: c.Request, ip: clientIP(c.Request, filter.trusted)}
+		action, name := filter.evaluate(req)
+		if action == constants.FILTER_MODE_DENY {
+			logging.Ctx(c.Request.Context()).Warnf("客户端被拒绝访问: %s %q %q (%s)",
+				req.ip, req.plexValue("X-Plex-Product"), c.Request.UserAgent(), name)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Client denied"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// loadClientFilter 获取当前配置对应的过滤规则，配置变化时重新编译
+func loadClientFilter() *clientFilter {
+	snapshot := config.Current()
+	if filter := currentFilter.Load(); filter != nil && filter.snapshot == snapshot {
+		return filter
+	}
+	filter := compileClientFilter(snapshot)
+	currentFilter.Store(filter)
+	return filter
+}
+
+// compileClientFilter 编译过滤规则，旧版 client_list 转换为追加在末尾的User-Agent规则
+// 配置已经过校验，无效的网段直接忽略
+func compileClientFilter(snapshot *config.Snapshot) *clientFilter {
+	setting := snapshot.ClientFilter
+	filter := &clientFilter{
+		snapshot:      snapshot,
+		defaultAction: setting.DefaultAction,
+		trusted:       parseNets(setting.TrustedProxies),
+	}
+
+	for i, rule := range setting.Rules {
+		filter.rules = append(filter.rules, filterRule{
+			name:       fmt.Sprintf("client_filter.rules[%d]", i),
+			action:     rule.Action,
+			nets:       parseNets(rule.CIDRs),
+			products:   lowerAll(rule.Products),
+			platforms:  lowerAll(rule.Platforms),
+			devices:    lowerAll(rule.Devices),
+			userAgents: lowerAll(rule.UserAgents),
+			users:      lowerAll(rule.Users),
+		})
+	}
+
+	if clients := utils.RemoveEmpty(setting.Clients); len(clients) > 0 {
+		filter.rules = append(filter.rules, filterRule{
+			name:       "client_filter.client_list",
+			action:     setting.Mode,
+			userAgents: lowerAll(clients),
+		})
+		// 白名单模式下未在列表中的客户端默认拒绝
+		if filter.defaultAction == "" && setting.Mode == constants.FILTER_MODE_ALLOW {
+			filter.defaultAction = constants.FILTER_MODE_DENY
+		}
+	}
+	if filter.defaultAction == "" {
+		filter.defaultAction = constants.FILTER_MODE_ALLOW
+	}
+	return filter
+}
+
+// evaluate 获取请求命中的第一条规则的动作及规则名称，未命中时使用默认动作
+func (f *clientFilter) evaluate(req *filterRequest) (string, string) {
+	for _, rule := range f.rules {
+		if rule.matches(req) {
+			return rule.action, rule.name
+		}
+	}
+	return f.defaultAction, "client_filter.default_action"
+}
+
+// matches 判断请求是否满足规则的全部条件，Plex用户名最后判断以减少查询
+func (rule *filterRule) matches(req *filterRequest) bool {
+	if len(rule.nets) > 0 && !containsIP(rule.nets, req.ip) {
+		return false
+	}
+	if len(rule.products) > 0 && !containsAny(req.plexValue("X-Plex-Product"), rule.products) {
+		return false
+	}
+	if len(rule.platforms) > 0 && !containsAny(req.plexValue("X-Plex-Platform"), rule.platforms) {
+		return false
+	}
+	if len(rule.devices) > 0 && !containsAny(req.plexValue("X-Plex-Device"), rule.devices) {
+		return false
+	}
+	if len(rule.userAgents) > 0 && !containsAny(req.r.UserAgent(), rule.userAgents) {
+		return false
+	}
+	if len(rule.users) > 0 {
+		user := strings.ToLower(req.username())
+		return user != "" && utils.Contains(rule.users, user)
+	}
+	return true
+}
+
+// plexValue 获取Plex客户端信息，优先使用请求头，其次使用查询参数
+func (req *filterRequest) plexValue(name string) string {
+	if value := req.r.Header.Get(name); value != "" {
+		return value
+	}
+	return req.r.URL.Query().Get(name)
+}
+
+// username 获取请求携带的令牌所属的Plex用户名，未携带令牌或查询失败时为空
+func (req *filterRequest) username() string {
+	if !req.resolved {
+		req.resolved = true
+		if token := requestPlexToken(req.r); token != "" {
+			req.user, _ = service.PlexUsername(req.r.Context(), token)
+		}
+	}
+	return req.user
+}
+
+// clientIP 获取客户端IP，仅在直接连接方为可信代理时使用 X-Forwarded-For 或 X-Real-IP
+// X-Forwarded-For 自右向左跳过可信代理，第一个不可信的地址即为客户端地址
+func clientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !containsIP(trusted, remote) {
+		return remote
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		addrs := strings.Split(strings.Join(values, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				// 格式错误的地址之前的内容不可信
+				break
+			}
+			remote = ip
+			if !containsIP(trusted, ip) {
+				break
+			}
+		}
+		return remote
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip
+	}
+	return remote
+}
+
+// parseNets 解析IP地址或CIDR网段列表
+func parseNets(values []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, value := range values {
+		if ipNet, err := utils.ParseCIDR(value); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// containsIP 判断IP是否属于其中一个网段
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny 判断取值是否包含其中一项，patterns 应为小写
+func containsAny(value string, patterns []string) bool {
+	if value == "" {
+		return false
+	}
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if strings.Contains(value, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerAll 将字符串列表转换为小写并移除空字符串
+func lowerAll(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range utils.RemoveEmpty(values) {
+		result = append(result, strings.ToLower(value))
+	}
+	return result
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"PlexWarp/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := parseNets([]string{"10.0.0.0/8", "127.0.0.1", "::1"})
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:   "直接连接",
+			remote: "203.0.113.5:51000",
+			want:   "203.0.113.5",
+		},
+		{
+			name:    "不可信的连接方忽略转发头",
+			remote:  "203.0.113.5:51000",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}},
+			want:    "203.0.113.5",
+		},
+		{
+			name:    "可信代理转发",
+			remote:  "10.0.0.2:51000",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "跳过多级可信代理",
+			remote:  "127.0.0.1:51000",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1, 10.1.1.1", "10.2.2.2"}},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "不信任伪造在左侧的地址",
+			remote:  "10.0.0.2:51000",
+			headers: map[string][]string{"X-Forwarded-For": {"192.168.1.1, 203.0.113.9"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "格式错误的地址之前的内容不可信",
+			remote:  "10.0.0.2:51000",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1, unknown, 10.3.3.3"}},
+			want:    "10.3.3.3",
+		},
+		{
+			name:    "全部为可信代理",
+			remote:  "10.0.0.2:51000",
+			headers: map[string][]string{"X-Forwarded-For": {"10.4.4.4"}},
+			want:    "10.4.4.4",
+		},
+		{
+			name:    "使用 X-Real-IP",
+			remote:  "[::1]:51000",
+			headers: map[string][]string{"X-Real-Ip": {" 2001:db8::1 "}},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "无效的 X-Real-IP",
+			remote:  "10.0.0.2:51000",
+			headers: map[string][]string{"X-Real-Ip": {"localhost"}},
+			want:    "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for key, values := range tt.headers {
+				r.Header[key] = values
+			}
+			if got := clientIP(r, trusted); got.String() != tt.want {
+				t.Errorf("clientIP = %s，预期 %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientFilterEvaluate(t *testing.T) {
+	rules := []config.ClientFilterRule{
+		{Action: "deny", CIDRs: []string{"192.168.100.0/24"}},
+		{Action: "allow", CIDRs: []string{"192.168.0.0/16"}, Products: []string{"Plex Web"}},
+		{Action: "deny", Platforms: []string{"Android"}, Devices: []string{"SHIELD"}},
+		{Action: "deny", Users: []string{"Guest"}},
+	}
+
+	tests := []struct {
+		name    string
+		setting config.ClientFilterSetting
+		remote  string
+		url     string
+		headers map[string]string
+		user    string
+		action  string
+		rule    string
+	}{
+		{
+			name:    "命中网段规则",
+			setting: config.ClientFilterSetting{Rules: rules},
+			remote:  "192.168.100.7:1",
+			action:  "deny",
+			rule:    "client_filter.rules[0]",
+		},
+		{
+			name:    "网段与客户端名称同时满足",
+			setting: config.ClientFilterSetting{Rules: rules},
+			remote:  "192.168.1.7:1",
+			headers: map[string]string{"X-Plex-Product": "plex web"},
+			action:  "allow",
+			rule:    "client_filter.rules[1]",
+		},
+		{
+			name:    "客户端信息取自查询参数",
+			setting: config.ClientFilterSetting{Rules: rules},
+			remote:  "203.0.113.5:1",
+			url:     "/library?X-Plex-Platform=Android&X-Plex-Device=NVIDIA+SHIELD+TV",
+			action:  "deny",
+			rule:    "client_filter.rules[2]",
+		},
+		{
+			name:    "条件未全部满足",
+			setting: config.ClientFilterSetting{Rules: rules},
+			remote:  "203.0.113.5:1",
+			headers: map[string]string{"X-Plex-Platform": "Android", "X-Plex-Device": "Pixel"},
+			action:  "allow",
+			rule:    "client_filter.default_action",
+		},
+		{
+			name:    "命中用户规则",
+			setting: config.ClientFilterSetting{Rules: rules},
+			remote:  "203.0.113.5:1",
+			user:    "guest",
+			action:  "deny",
+			rule:    "client_filter.rules[3]",
+		},
+		{
+			name:    "未知用户不命中用户规则",
+			setting: config.ClientFilterSetting{Rules: rules, DefaultAction: "deny"},
+			remote:  "203.0.113.5:1",
+			action:  "deny",
+			rule:    "client_filter.default_action",
+		},
+		{
+			name:    "旧版白名单命中",
+			setting: config.ClientFilterSetting{Mode: "allow", Clients: []string{"Infuse", ""}},
+			remote:  "203.0.113.5:1",
+			headers: map[string]string{"User-Agent": "infuse/7.0"},
+			action:  "allow",
+			rule:    "client_filter.client_list",
+		},
+		{
+			name:    "旧版白名单默认拒绝",
+			setting: config.ClientFilterSetting{Mode: "allow", Clients: []string{"Infuse"}},
+			remote:  "203.0.113.5:1",
+			headers: map[string]string{"User-Agent": "curl/8.0"},
+			action:  "deny",
+			rule:    "client_filter.default_action",
+		},
+		{
+			name:    "旧版黑名单追加在规则之后",
+			setting: config.ClientFilterSetting{Mode: "deny", Clients: []string{"curl"}, Rules: rules[1:2]},
+			remote:  "192.168.1.7:1",
+			headers: map[string]string{"User-Agent": "curl/8.0", "X-Plex-Product": "Plex Web"},
+			action:  "allow",
+			rule:    "client_filter.rules[0]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := compileClientFilter(&config.Snapshot{ClientFilter: tt.setting})
+
+			url := tt.url
+			if url == "" {
+				url = "/library"
+			}
+			r := httptest.NewRequest(http.MethodGet, url, nil)
+			r.RemoteAddr = tt.remote
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			// 预先设置用户名，避免查询Plex
+			req := &filterRequest{r: r, ip: clientIP(r, filter.trusted), user: tt.user, resolved: true}
+
+			action, rule := filter.evaluate(req)
+			if action != tt.action || rule != tt.rule {
+				t.Errorf("evaluate = (%s, %s)，预期 (%s, %s)", action, rule, tt.action, tt.rule)
+			}
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
-	"PlexWarp/internal/config"
 	"PlexWarp/internal/logging"
+	"PlexWarp/internal/metrics"
+	"PlexWarp/internal/service"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,7 +15,7 @@ import (
 // Logger 日志中间件
 func Logger() gin.HandlerFunc {
 	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		logging.AccessInfof("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"",
+		logging.AccessCtx(param.Request.Context()).Infof("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"",
 			param.ClientIP,
 			param.TimeStamp.Format(time.RFC1123),
 			param.Method,
@@ -24,14 +26,36 @@ func Logger() gin.HandlerFunc {
 			param.Request.UserAgent(),
 			param.ErrorMessage,
 		)
+
+		class := routeClass(param.Path)
+		metrics.Requests.WithLabelValues(class, methodLabel(param.Method), strconv.Itoa(param.StatusCode)).Inc()
+		metrics.RequestDuration.WithLabelValues(class).Observe(param.Latency.Seconds())
 		return ""
 	})
 }
 
+// routeClass 获取请求的路由类别，PlexWarp自身的接口为 internal
+func routeClass(path string) string {
+	if strings.HasPrefix(path, "/api/") || path == "/metrics" {
+		return "internal"
+	}
+	return string(service.ClassifyRoute(path))
+}
+
+// methodLabel 获取请求方法的指标标签，非标准的请求方法统一记为 other，避免标签数量无限增长
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
 // Recovery 恢复中间件
 func Recovery() gin.HandlerFunc {
 	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
-		logging.Errorf("Panic recovered: %v", recovered)
+		logging.Ctx(c.Request.Context()).Errorf("Panic recovered: %v", recovered)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 	})
 }
@@ -41,8 +65,8 @@ func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Plex-Token")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Plex-Token, X-Api-Key")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type, X-Request-Id")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
@@ -54,45 +78,6 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
-// ClientFilter 客户端过滤中间件
-func ClientFilter() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if !config.ClientFilter.Enable {
-			c.Next()
-			return
-		}
-
-		userAgent := c.GetHeader("User-Agent")
-		if userAgent == "" {
-			c.Next()
-			return
-		}
-
-		isAllowed := false
-		for _, client := range config.ClientFilter.Clients {
-			if strings.Contains(strings.ToLower(userAgent), strings.ToLower(client)) {
-				isAllowed = true
-				break
-			}
-		}
-
-		// 根据过滤模式决定是否允许访问
-		if config.ClientFilter.Mode == "allow" && !isAllowed {
-			logging.Warnf("客户端被拒绝访问: %s", userAgent)
-			c.JSON(http.StatusForbidden, gin.H{"error": "Client not allowed"})
-			c.Abort()
-			return
-		} else if config.ClientFilter.Mode == "deny" && isAllowed {
-			logging.Warnf("客户端被拒绝访问: %s", userAgent)
-			c.JSON(http.StatusForbidden, gin.H{"error": "Client denied"})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // RateLimiter 简单的速率限制中间件
 func RateLimiter() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"PlexWarp/internal/logging"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader 请求ID响应头
+const RequestIDHeader = "X-Request-Id"
+
+// 允许沿用的客户端请求ID，避免将任意内容写入日志
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID 请求ID中间件，为每个请求生成ID并通过 X-Request-Id 响应头返回
+// 前置反向代理已设置 X-Request-Id 时沿用该ID，便于跨服务追踪
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDRegex.MatchString(id) {
+			id = newRequestID()
+		}
+
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// newRequestID 生成16位十六进制随机ID
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
@@ -2,7 +2,10 @@ package router
 
 import (
 	"PlexWarp/internal/handler"
+	"PlexWarp/internal/metrics"
 	"PlexWarp/internal/middleware"
+	"PlexWarp/internal/web"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,21 +15,52 @@ func InitRouter() *gin.Engine {
 	r := gin.New()
 
 	// 添加中间件
+	r.Use(middleware.RequestID())
 	r.Use(middleware.Logger())
 	r.Use(middleware.Recovery())
+	r.Use(middleware.Drain())
 	r.Use(middleware.CORS())
 	r.Use(middleware.Security())
 	r.Use(middleware.ClientFilter())
 
-	// API路由组
-	api := r.Group("/api")
+	// API路由组，配置认证方式后需要认证
+	api := r.Group("/api", middleware.Auth())
 	{
 		api.GET("/health", handler.HealthHandler)
 		api.GET("/version", handler.VersionHandler)
 	}
 
-	// Plex代理路由 - 捕获所有其他请求
-	r.NoRoute(handler.ProxyHandler)
+	// 其余接口包含直链、客户端地址及配置等信息，未配置认证方式时不可用
+	protected := api.Group("", middleware.RequireAuth())
+	{
+		protected.GET("/probes", handler.ProbeHandler)
+		protected.GET("/config", handler.ConfigHandler)
+		protected.GET("/upstreams", handler.UpstreamsHandler)
+		protected.GET("/sessions", handler.SessionsHandler)
+		protected.GET("/decisions", handler.DecisionsHandler)
+		protected.GET("/stats", handler.StatsHandler)
+	}
+
+	// 管理接口
+	admin := protected.Group("/admin")
+	{
+		admin.GET("/rules", handler.RulesHandler)
+		admin.POST("/rules/test", handler.TestRulesHandler)
+		admin.GET("/rules/:set", handler.RuleListHandler)
+		admin.POST("/rules/:set", handler.AddRuleHandler)
+		admin.POST("/rules/:set/move", handler.MoveRuleHandler)
+		admin.PUT("/rules/:set/:index", handler.UpdateRuleHandler)
+		admin.DELETE("/rules/:set/:index", handler.DeleteRuleHandler)
+	}
+
+	// 控制台页面，/warp 自动重定向到 /warp/
+	r.GET("/warp/*filepath", gin.WrapH(http.StripPrefix("/warp", web.Handler())))
+
+	// Prometheus指标，未配置认证方式时不可用
+	r.GET("/metrics", middleware.Auth(), middleware.RequireAuth(), gin.WrapH(metrics.Handler()))
+
+	// Plex代理路由 - 捕获所有其他请求，WebSocket等升级请求单独隧道转发
+	r.NoRoute(handler.UpgradeHandler, handler.ProxyHandler)
 
 	return r
 }
\ No newline at end of file
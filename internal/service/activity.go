@@ -0,0 +1,211 @@
+package service
+
+import (
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 保留的最近重定向决策数量
+const decisionHistoryMax = 100
+
+// Session 正在经由PlexWarp传输的媒体流
+type Session struct {
+	ID        string    `json:"id"`         // 请求ID
+	Client    string    `json:"client"`     // 客户端地址
+	Product   string    `json:"product"`    // 客户端名称，取自 X-Plex-Product 或 User-Agent
+	Path      string    `json:"path"`       // 请求路径
+	Source    string    `json:"source"`     // 数据来源：plex、direct、local
+	StartedAt time.Time `json:"started_at"` // 开始时间
+	Bytes     int64     `json:"bytes"`      // 已传输的字节数
+
+	written *atomic.Int64
+}
+
+// sessionWriter 统计媒体流已写入的字节数，传输期间可以并发读取
+type sessionWriter struct {
+	http.ResponseWriter
+	written *atomic.Int64
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written.Add(int64(n))
+	return n, err
+}
+
+// Flush 实现 http.Flusher，以便事件流等响应及时发送
+func (w *sessionWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 获取原始的 ResponseWriter
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DecisionRecord 一次strm重定向的决策记录
+type DecisionRecord struct {
+	RequestID   string    `json:"request_id"`
+	RequestPath string    `json:"request_path"`
+	Time        time.Time `json:"time"`
+	RedirectDecision
+}
+
+// RuleHit 规则命中次数
+type RuleHit struct {
+	Set   string      `json:"set"`   // 规则列表：path_mapping、symlink、last_link
+	Index int         `json:"index"` // 规则序号
+	Rule  interface{} `json:"rule"`  // 规则内容
+	Hits  uint64      `json:"hits"`  // 命中次数
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[*Session]struct{})
+
+	decisionsMu sync.Mutex
+	decisions   []DecisionRecord
+
+	// 规则命中次数，以规则内容为键，调整规则顺序后计数不变
+	ruleHits sync.Map
+)
+
+// TrackSession 登记正在传输的媒体流
+// 返回统计传输字节数的 ResponseWriter，媒体数据需经由其写入；返回的函数在传输结束时调用
+func TrackSession(w http.ResponseWriter, r *http.Request, source string) (http.ResponseWriter, func()) {
+	product := r.Header.Get("X-Plex-Product")
+	if product == "" {
+		product = r.URL.Query().Get("X-Plex-Product")
+	}
+	if product == "" {
+		product = r.UserAgent()
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	session := &Session{
+		ID:        logging.RequestID(r.Context()),
+		Client:    client,
+		Product:   product,
+		Path:      r.URL.Path,
+		Source:    source,
+		StartedAt: time.Now(),
+		written:   new(atomic.Int64),
+	}
+
+	sessionsMu.Lock()
+	sessions[session] = struct{}{}
+	sessionsMu.Unlock()
+
+	return &sessionWriter{ResponseWriter: w, written: session.written}, func() {
+		sessionsMu.Lock()
+		delete(sessions, session)
+		sessionsMu.Unlock()
+	}
+}
+
+// ActiveSessions 获取正在传输的媒体流，按开始时间排序
+func ActiveSessions() []Session {
+	sessionsMu.Lock()
+	result := make([]Session, 0, len(sessions))
+	for session := range sessions {
+		item := *session
+		item.Bytes = session.written.Load()
+		result = append(result, item)
+	}
+	sessionsMu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// recordDecision 记录重定向决策，action 为实际执行的动作，并统计命中的规则
+func recordDecision(r *http.Request, started time.Time, decision *RedirectDecision, action string) {
+	record := DecisionRecord{
+		RequestID:        logging.RequestID(r.Context()),
+		RequestPath:      r.URL.Path,
+		Time:             started,
+		RedirectDecision: *decision,
+	}
+	record.Action = action
+
+	decisionsMu.Lock()
+	decisions = append(decisions, record)
+	if len(decisions) > decisionHistoryMax {
+		decisions = decisions[len(decisions)-decisionHistoryMax:]
+	}
+	decisionsMu.Unlock()
+
+	// 决策期间配置可能已重载，规则序号超出范围时不统计
+	cfg := config.Current()
+	if mapping := decision.Mapping; mapping != nil {
+		switch {
+		case mapping.RuleType == "symlink" && mapping.RuleIndex < len(cfg.Symlink.Rules):
+			countRuleHit("symlink", cfg.Symlink.Rules[mapping.RuleIndex])
+		case mapping.RuleType == "path_mapping" && mapping.RuleIndex < len(cfg.PathMapping.Rules):
+			countRuleHit("path_mapping", cfg.PathMapping.Rules[mapping.RuleIndex])
+		}
+	}
+	if match := decision.Rule; match != nil {
+		countRuleHit("last_link", match.Rule)
+	}
+}
+
+// RecentDecisions 获取最近的重定向决策，按时间倒序排列
+func RecentDecisions() []DecisionRecord {
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+
+	results := make([]DecisionRecord, 0, len(decisions))
+	for i := len(decisions) - 1; i >= 0; i-- {
+		results = append(results, decisions[i])
+	}
+	return results
+}
+
+// countRuleHit 增加规则的命中次数
+func countRuleHit(set string, rule interface{}) {
+	counter, _ := ruleHits.LoadOrStore(ruleHitKey(set, rule), new(atomic.Uint64))
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// ruleHitKey 获取规则命中计数的键
+func ruleHitKey(set string, rule interface{}) string {
+	return fmt.Sprintf("%s\x00%+v", set, rule)
+}
+
+// RuleHits 获取当前规则的命中次数
+func RuleHits() []RuleHit {
+	cfg := config.Current()
+	result := []RuleHit{}
+	add := func(set string, index int, rule interface{}) {
+		hit := RuleHit{Set: set, Index: index, Rule: rule}
+		if counter, ok := ruleHits.Load(ruleHitKey(set, rule)); ok {
+			hit.Hits = counter.(*atomic.Uint64).Load()
+		}
+		result = append(result, hit)
+	}
+	for i, rule := range cfg.Symlink.Rules {
+		add("symlink", i, rule)
+	}
+	for i, rule := range cfg.PathMapping.Rules {
+		add("path_mapping", i, rule)
+	}
+	for i, rule := range cfg.StrmRedirect.LastLinkRules {
+		add("last_link", i, rule)
+	}
+	return result
+}
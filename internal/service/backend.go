@@ -0,0 +1,70 @@
+package service
+
+import (
+	"PlexWarp/constants"
+	"PlexWarp/internal/config"
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Backend 媒体服务器后端，封装不同媒体服务器的接口差异
+// strm读取、路径映射及302重定向流程对全部后端通用
+type Backend interface {
+	// Type 后端类型
+	Type() constants.PlexServerType
+	// TokenParam 附加配置的令牌时使用的查询参数名
+	TokenParam() string
+	// HasClientToken 判断客户端请求是否已携带令牌
+	HasClientToken(query url.Values, header http.Header) bool
+	// HealthPath 连接检查及健康检查使用的接口
+	HealthPath() string
+	// TokenCheckPath 检查令牌有效性使用的需要认证的接口
+	TokenCheckPath() string
+	// ClassifyRoute 根据请求路径判断路由类别
+	ClassifyRoute(path string) RouteClass
+	// IsTranscodePath 判断请求路径是否为转码请求
+	IsTranscodePath(path string) bool
+	// ParseMediaRequest 解析播放请求，非播放请求返回 nil
+	ParseMediaRequest(path string, query url.Values) *MediaRequest
+	// LookupFile 查询播放请求对应的媒体文件在服务器上的实际路径
+	LookupFile(ctx context.Context, req *MediaRequest) (string, error)
+	// Invalidate 播放失败时使缓存的查询结果失效
+	Invalidate(req *MediaRequest)
+	// PlayPath 构建直接播放指定ID的请求路径，ID为Plex分段ID或Jellyfin/Emby条目ID
+	PlayPath(id string) string
+}
+
+// MediaRequest 播放请求
+type MediaRequest struct {
+	ItemID     string // 条目ID，Plex为ratingKey
+	PartID     string // 分段ID，Jellyfin/Emby为媒体源ID
+	MediaIndex int    // 媒体版本序号，仅Plex转码请求使用
+	PartIndex  int    // 分段序号，仅Plex转码请求使用
+}
+
+// 已注册的后端
+var backends = map[constants.PlexServerType]Backend{
+	constants.PlexServerTypePlex:     plexBackend{},
+	constants.PlexServerTypeJellyfin: embyBackend{serverType: constants.PlexServerTypeJellyfin},
+	constants.PlexServerTypeEmby:     embyBackend{serverType: constants.PlexServerTypeEmby},
+}
+
+// 启动时根据 server.type 选定的后端，修改 server.type 需重启才能生效
+var activeBackend Backend
+
+// CurrentBackend 获取启动时选定的后端，初始化之前按当前配置选择
+func CurrentBackend() Backend {
+	if activeBackend != nil {
+		return activeBackend
+	}
+	return backendFor(config.Current().Server.Type)
+}
+
+// backendFor 获取服务器类型对应的后端，未知类型时使用Plex
+func backendFor(serverType string) Backend {
+	if backend, ok := backends[constants.PlexServerType(serverType)]; ok {
+		return backend
+	}
+	return plexBackend{}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"PlexWarp/constants"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// 播放请求路径正则表达式，Emby 的接口可带 /emby 前缀：
+	// /Videos/{id}/stream[.mkv]、/Videos/{id}/original.mkv、/Videos/{id}/master.m3u8、/Audio/{id}/universal
+	embyStreamRegex = regexp.MustCompile(`(?i)^(?:/emby)?/(?:videos|audio)/([^/]+)/(stream|original|universal|master|main|live)\b`)
+	// 下载请求路径正则表达式
+	embyDownloadRegex = regexp.MustCompile(`(?i)^(?:/emby)?/items/([^/]+)/download`)
+)
+
+// EmbyItemsResponse Jellyfin/Emby 条目查询响应
+type EmbyItemsResponse struct {
+	Items []EmbyItem `json:"Items"`
+}
+
+// EmbyItem Jellyfin/Emby 媒体条目，strm条目的 Path 为strm文件在服务器上的实际路径
+type EmbyItem struct {
+	ID   string `json:"Id"`
+	Name string `json:"Name"`
+	Path string `json:"Path"`
+}
+
+// embyBackend Jellyfin及Emby后端，Jellyfin由Emby分支而来，两者的播放接口基本一致
+type embyBackend struct {
+	serverType constants.PlexServerType
+}
+
+func (b embyBackend) Type() constants.PlexServerType {
+	return b.serverType
+}
+
+func (embyBackend) TokenParam() string {
+	return "api_key"
+}
+
+func (embyBackend) HasClientToken(query url.Values, header http.Header) bool {
+	if query.Get("api_key") != "" || query.Get("ApiKey") != "" {
+		return true
+	}
+	if header.Get("X-Emby-Token") != "" || header.Get("X-MediaBrowser-Token") != "" {
+		return true
+	}
+	// Authorization: MediaBrowser Client="...", Token="..."
+	for _, key := range []string{"Authorization", "X-Emby-Authorization"} {
+		if strings.Contains(header.Get(key), "Token=") {
+			return true
+		}
+	}
+	return false
+}
+
+func (embyBackend) HealthPath() string {
+	return "/System/Info/Public"
+}
+
+func (embyBackend) TokenCheckPath() string {
+	return "/System/Info"
+}
+
+func (b embyBackend) ClassifyRoute(path string) RouteClass {
+	lower := strings.ToLower(path)
+	switch {
+	case b.IsTranscodePath(path):
+		return RouteClassTranscode
+	case embyStreamRegex.MatchString(path) ||
+		embyDownloadRegex.MatchString(path) ||
+		strings.HasSuffix(lower, "/socket") ||
+		strings.Contains(lower, "/subtitles/"):
+		return RouteClassMedia
+	default:
+		return RouteClassAPI
+	}
+}
+
+func (embyBackend) IsTranscodePath(path string) bool {
+	lower := strings.ToLower(path)
+	for _, keyword := range []string{".m3u8", "/hls", "/universal"} {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMediaRequest 解析播放及下载请求，MediaSourceId 参数指定播放的版本
+func (embyBackend) ParseMediaRequest(path string, query url.Values) *MediaRequest {
+	matches := embyStreamRegex.FindStringSubmatch(path)
+	if matches == nil {
+		matches = embyDownloadRegex.FindStringSubmatch(path)
+	}
+	if matches == nil {
+		return nil
+	}
+
+	req := &MediaRequest{ItemID: matches[1], PartID: query.Get("MediaSourceId")}
+	if req.PartID == "" {
+		req.PartID = query.Get("mediaSourceId")
+	}
+	return req
+}
+
+// LookupFile 查询条目的实际路径
+// 多版本条目中每个版本都是独立的条目，媒体源ID与版本的条目ID相同，因此优先按媒体源ID查询
+func (b embyBackend) LookupFile(ctx context.Context, req *MediaRequest) (string, error) {
+	if req.PartID != "" && req.PartID != req.ItemID {
+		if file, err := b.itemPath(ctx, req.PartID); err == nil {
+			return file, nil
+		}
+	}
+	return b.itemPath(ctx, req.ItemID)
+}
+
+func (embyBackend) Invalidate(req *MediaRequest) {}
+
+func (embyBackend) PlayPath(id string) string {
+	return fmt.Sprintf("/Videos/%s/stream", id)
+}
+
+// itemPath 查询条目的实际路径
+func (b embyBackend) itemPath(ctx context.Context, id string) (string, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+
+	path := "/Items"
+	ctx, cancel := RouteContext(ctx, path)
+	defer cancel()
+
+	resp, err := ProxyRequestWithBody(ctx, http.MethodGet, path, url.Values{
+		"Ids":       {id},
+		"Fields":    {"Path"},
+		"Recursive": {"true"},
+	}, header, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s服务器响应异常: %s %d", b.serverType, path, resp.StatusCode)
+	}
+
+	var items EmbyItemsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return "", fmt.Errorf("解析%s响应失败: %v", b.serverType, err)
+	}
+	for _, item := range items.Items {
+		if sameItemID(item.ID, id) && item.Path != "" {
+			return item.Path, nil
+		}
+	}
+	return "", fmt.Errorf("未找到条目对应的文件: %s", id)
+}
+
+// sameItemID 比较条目ID，Jellyfin的ID在请求中可能带有连字符
+func sameItemID(a, b string) bool {
+	return strings.EqualFold(strings.ReplaceAll(a, "-", ""), strings.ReplaceAll(b, "-", ""))
+}
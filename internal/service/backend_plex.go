@@ -0,0 +1,117 @@
+package service
+
+import (
+	"PlexWarp/constants"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// 媒体文件路径正则表达式
+	plexPartRegex = regexp.MustCompile(`/library/parts/(\d+)/(\d+)/file`)
+	// 转码相关路径正则表达式
+	plexTranscodeRegex = regexp.MustCompile(`/video/:/transcode/`)
+	// 条目元数据路径正则表达式
+	plexMetadataPathRegex = regexp.MustCompile(`^/library/metadata/(\d+)`)
+)
+
+// plexBackend Plex后端
+type plexBackend struct{}
+
+func (plexBackend) Type() constants.PlexServerType {
+	return constants.PlexServerTypePlex
+}
+
+func (plexBackend) TokenParam() string {
+	return "X-Plex-Token"
+}
+
+func (plexBackend) HasClientToken(query url.Values, header http.Header) bool {
+	return header.Get("X-Plex-Token") != "" || query.Get("X-Plex-Token") != ""
+}
+
+func (plexBackend) HealthPath() string {
+	return "/identity"
+}
+
+func (plexBackend) TokenCheckPath() string {
+	return "/library/sections"
+}
+
+func (plexBackend) ClassifyRoute(path string) RouteClass {
+	switch {
+	case strings.Contains(path, "/video/:/transcode/") || strings.Contains(path, "/music/:/transcode/"):
+		return RouteClassTranscode
+	case strings.HasPrefix(path, "/library/parts/") ||
+		strings.HasPrefix(path, "/:/websockets/") ||
+		strings.HasPrefix(path, "/:/eventsource/"):
+		return RouteClassMedia
+	default:
+		return RouteClassAPI
+	}
+}
+
+func (plexBackend) IsTranscodePath(path string) bool {
+	// 检查路径中是否包含转码相关的关键词
+	transcodeKeywords := []string{
+		"transcode",
+		"universal",
+		"decision",
+		"start",
+	}
+
+	pathLower := strings.ToLower(path)
+	for _, keyword := range transcodeKeywords {
+		if strings.Contains(pathLower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMediaRequest 解析媒体文件请求 /library/parts/{partID}/{ts}/file，
+// 及通过 path=/library/metadata/{ratingKey} 参数定位条目的转码请求
+func (plexBackend) ParseMediaRequest(path string, query url.Values) *MediaRequest {
+	if matches := plexPartRegex.FindStringSubmatch(path); matches != nil {
+		return &MediaRequest{PartID: matches[1]}
+	}
+
+	// 转码会话的分片请求不携带条目路径，无法定位文件
+	if plexTranscodeRegex.MatchString(path) {
+		matches := plexMetadataPathRegex.FindStringSubmatch(query.Get("path"))
+		if matches == nil {
+			return nil
+		}
+		req := &MediaRequest{ItemID: matches[1]}
+		req.MediaIndex, _ = strconv.Atoi(query.Get("mediaIndex"))
+		req.PartIndex, _ = strconv.Atoi(query.Get("partIndex"))
+		return req
+	}
+	return nil
+}
+
+func (plexBackend) LookupFile(ctx context.Context, req *MediaRequest) (string, error) {
+	if req.PartID != "" {
+		return LookupPartFile(ctx, req.PartID)
+	}
+	if req.ItemID == "" {
+		return "", fmt.Errorf("转码请求缺少条目路径参数")
+	}
+	return GetMetadataFilePath(ctx, req.ItemID, req.MediaIndex, req.PartIndex)
+}
+
+// Invalidate 文件可能已被移动或删除，使分段索引失效以便下次重新查询
+func (plexBackend) Invalidate(req *MediaRequest) {
+	if req.PartID != "" {
+		InvalidatePart(req.PartID)
+	}
+}
+
+func (plexBackend) PlayPath(id string) string {
+	return fmt.Sprintf("/library/parts/%s/0/file", id)
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"PlexWarp/constants"
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 重定向决策的最终动作
+const (
+	DecisionPassthrough = "passthrough"                  // 不处理，按原请求代理到Plex
+	DecisionRedirect    = constants.STRM_ACTION_REDIRECT // 302重定向到直链
+	DecisionProxy       = constants.STRM_ACTION_PROXY    // 由PlexWarp代理直链
+	DecisionLocal       = "local"                        // 由PlexWarp直接提供本地文件
+	DecisionFallback    = "fallback"                     // 处理失败，回退到原请求
+	DecisionError       = "error"                        // 处理失败，返回错误
+)
+
+// ErrFallback 表示需要回退到原始请求，由Plex处理
+var ErrFallback = errors.New("fallback to original")
+
+// ErrForbiddenPath strm指向的本地文件不在允许直接提供的目录中
+var ErrForbiddenPath = errors.New("local media path is outside the allowed directories")
+
+// DecisionStep 决策过程中的一个步骤
+type DecisionStep struct {
+	Name   string `json:"name"`
+	Detail string `json:"detail"`
+}
+
+// RedirectDecision 一次strm重定向的决策结果及完整过程
+type RedirectDecision struct {
+	FilePath string             `json:"file_path"`
+	Mapping  *PathMappingResult `json:"mapping,omitempty"`
+	Link     string             `json:"link,omitempty"`
+	Links    []string           `json:"links,omitempty"`
+	Action   string             `json:"action"`
+	Rule     *LinkRuleMatch     `json:"rule,omitempty"`
+	Steps    []DecisionStep     `json:"steps"`
+	Error    string             `json:"error,omitempty"`
+
+	err error
+}
+
+// Err 获取决策失败的原因
+func (d *RedirectDecision) Err() error {
+	return d.err
+}
+
+// step 记录一个决策步骤
+func (d *RedirectDecision) step(name, format string, args ...interface{}) {
+	d.Steps = append(d.Steps, DecisionStep{Name: name, Detail: fmt.Sprintf(format, args...)})
+}
+
+// passthrough 结束决策，不处理该请求
+func (d *RedirectDecision) passthrough(reason string) *RedirectDecision {
+	d.step("判断", "%s", reason)
+	d.Action = DecisionPassthrough
+	return d
+}
+
+// fail 结束决策，根据配置回退或返回错误，指向不允许的本地文件时总是返回错误
+func (d *RedirectDecision) fail(err error) *RedirectDecision {
+	d.err = err
+	d.Error = err.Error()
+	if config.Current().Plex302.FallbackOriginal && !errors.Is(err, ErrForbiddenPath) {
+		d.Action = DecisionFallback
+	} else {
+		d.Action = DecisionError
+	}
+	return d
+}
+
+// Resolve 对媒体文件执行完整的重定向决策，但不写入任何响应
+// filePath 为媒体文件在Plex服务器上的实际路径，requestPath 为客户端请求路径
+func (s *StrmService) Resolve(ctx context.Context, filePath, requestPath string) *RedirectDecision {
+	d := &RedirectDecision{FilePath: filePath}
+	if !s.ShouldRedirect(filePath, requestPath) {
+		return d.passthrough(s.skipReason(filePath, requestPath))
+	}
+
+	// 映射strm文件路径并读取内容
+	mapping := s.MapPath(filePath)
+	d.Mapping = &mapping
+	d.step("strm路径映射", "%s", describeMapping(mapping))
+	content, err := s.readStrmFile(mapping.Mapped)
+	if err != nil {
+		metrics.StrmReadFailures.Inc()
+		return d.fail(err)
+	}
+	d.step("strm内容", "%s", content)
+
+	// 解析候选链接
+	var links []string
+	var forbidden error
+	for _, line := range strmLines(content) {
+		if !IsHTTPLink(line) && strings.HasPrefix(line, "/") {
+			d.step("本地路径映射", "%s", describeMapping(s.MapPath(line)))
+		}
+		link, err := s.resolveStrmLine(ctx, line)
+		if err != nil {
+			d.step("跳过候选", "%v", err)
+			if errors.Is(err, ErrForbiddenPath) {
+				forbidden = err
+			}
+			continue
+		}
+		links = append(links, link)
+	}
+	if len(links) == 0 {
+		if forbidden != nil {
+			return d.fail(fmt.Errorf("no usable link in strm file: %s: %w", mapping.Mapped, forbidden))
+		}
+		return d.fail(fmt.Errorf("no usable link in strm file: %s", mapping.Mapped))
+	}
+	d.Links = links
+	d.step("候选链接", "%s", strings.Join(links, ", "))
+
+	// 检查链接有效性
+	link, err := s.SelectValidLink(ctx, links)
+	if err != nil {
+		return d.fail(err)
+	}
+	d.Link = link
+	if config.Current().Plex302.CheckLinkValidity {
+		d.step("有效性检查", "选用 %s", link)
+	}
+
+	// 本地文件由PlexWarp直接提供
+	if !IsHTTPLink(link) {
+		d.step("最终链接规则", "本地文件，由PlexWarp直接提供")
+		d.Action = DecisionLocal
+		return d
+	}
+
+	// 根据最终链接规则决定重定向或代理
+	action, match := LinkAction(link)
+	d.Rule = match
+	switch {
+	case !config.Current().StrmRedirect.Enable:
+		d.step("最终链接规则", "strm_redirect.enable 未开启，默认 %s", action)
+	case match == nil:
+		d.step("最终链接规则", "未命中任何规则，默认 %s", action)
+	default:
+		d.step("最终链接规则", "命中 last_link_rules[%d] (%s %q) -> %s", match.Index, match.Rule.MatchType, match.Pattern, action)
+	}
+
+	switch action {
+	case constants.STRM_ACTION_PROXY, constants.STRM_ACTION_REDIRECT:
+		d.Action = action
+	default:
+		return d.fail(fmt.Errorf("unsupported strm action: %s", action))
+	}
+	return d
+}
+
+// skipReason 获取不处理该请求的原因，与 ShouldRedirect 的判断顺序一致
+func (s *StrmService) skipReason(filePath, requestPath string) string {
+	plex302 := config.Current().Plex302
+	switch {
+	case !plex302.Enable:
+		return "plex302.enable 未开启"
+	case !s.IsStrmFile(filePath):
+		return "不是strm文件"
+	case !s.IsMediaPath(filePath):
+		return "不在 plex302.media_mount_paths 中"
+	case !plex302.TranscodeEnable && s.isTranscodeRequest(requestPath):
+		return "转码请求，且 plex302.transcode_enable 未开启"
+	default:
+		return "无需处理"
+	}
+}
+
+// describeMapping 描述路径映射结果
+func describeMapping(result PathMappingResult) string {
+	if result.RuleType == "" {
+		return fmt.Sprintf("%s（未命中映射规则）", result.Original)
+	}
+	return fmt.Sprintf("%s -> %s（命中 %s.rules[%d]）", result.Original, result.Mapped, result.RuleType, result.RuleIndex)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// PlexMediaContainer Plex API响应的顶层容器
+type PlexMediaContainer struct {
+	MediaContainer struct {
+		Size      int             `json:"size"`
+		TotalSize int             `json:"totalSize"`
+		Metadata  []PlexMetadata  `json:"Metadata"`
+		Directory []PlexDirectory `json:"Directory"`
+	} `json:"MediaContainer"`
+}
+
+// PlexDirectory Plex媒体库分区信息
+type PlexDirectory struct {
+	Key       string `json:"key"`
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	UpdatedAt int64  `json:"updatedAt"`
+	ScannedAt int64  `json:"scannedAt"`
+}
+
+// PlexMetadata Plex媒体条目元数据
+type PlexMetadata struct {
+	RatingKey string      `json:"ratingKey"`
+	Key       string      `json:"key"`
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Media     []PlexMedia `json:"Media"`
+}
+
+// PlexMedia Plex媒体版本信息
+type PlexMedia struct {
+	ID   int64      `json:"id"`
+	Part []PlexPart `json:"Part"`
+}
+
+// PlexPart Plex媒体分段信息，File 为媒体文件在Plex服务器上的实际路径
+type PlexPart struct {
+	ID   int64  `json:"id"`
+	Key  string `json:"key"`
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+// 按分段ID检索时尝试的媒体类型：1=电影，4=剧集，10=音乐
+var partLookupTypes = []string{"1", "4", "10"}
+
+// ErrFileNotFound Plex中不存在对应的媒体文件，如照片等未检索的类型
+var ErrFileNotFound = errors.New("未找到对应的文件")
+
+// GetPlexJSON 请求Plex API并解析JSON响应
+func GetPlexJSON(ctx context.Context, path string, query url.Values) (*PlexMediaContainer, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+
+	ctx, cancel := RouteContext(ctx, path)
+	defer cancel()
+
+	resp, err := ProxyRequestWithBody(ctx, http.MethodGet, path, query, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Plex服务器响应异常: %s %d", path, resp.StatusCode)
+	}
+
+	var container PlexMediaContainer
+	if err := json.NewDecoder(resp.Body).Decode(&container); err != nil {
+		return nil, fmt.Errorf("解析Plex响应失败: %v", err)
+	}
+	return &container, nil
+}
+
+// GetPartFilePath 根据分段ID查询媒体文件的实际路径
+func GetPartFilePath(ctx context.Context, partID string) (string, error) {
+	id, err := strconv.ParseInt(partID, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("无效的分段ID: %s", partID)
+	}
+
+	for _, mediaType := range partLookupTypes {
+		container, err := GetPlexJSON(ctx, "/library/all", url.Values{
+			"type":    {mediaType},
+			"part.id": {partID},
+		})
+		if err != nil {
+			return "", err
+		}
+		if file := findPartFile(container.MediaContainer.Metadata, id); file != "" {
+			return file, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: 分段 %s", ErrFileNotFound, partID)
+}
+
+// GetMetadataFilePath 根据条目ID及媒体、分段序号查询媒体文件的实际路径
+func GetMetadataFilePath(ctx context.Context, ratingKey string, mediaIndex, partIndex int) (string, error) {
+	container, err := GetPlexJSON(ctx, "/library/metadata/"+ratingKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for _, metadata := range container.MediaContainer.Metadata {
+		if mediaIndex < 0 || mediaIndex >= len(metadata.Media) {
+			continue
+		}
+		parts := metadata.Media[mediaIndex].Part
+		if partIndex < 0 || partIndex >= len(parts) {
+			continue
+		}
+		if file := parts[partIndex].File; file != "" {
+			return file, nil
+		}
+	}
+
+	return "", fmt.Errorf("未找到条目对应的文件: %s", ratingKey)
+}
+
+// findPartFile 在元数据列表中查找指定分段ID对应的文件路径
+func findPartFile(metadataList []PlexMetadata, partID int64) string {
+	for _, metadata := range metadataList {
+		for _, media := range metadata.Media {
+			for _, part := range media.Part {
+				if part.ID == partID {
+					return part.File
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"PlexWarp/internal/logging"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// 所有者令牌校验结果的缓存时间
+	ownerTokenTTL = 5 * time.Minute
+	// 非所有者令牌校验结果的缓存时间，较短以便令牌权限变更后及时生效
+	ownerRejectTTL = 30 * time.Second
+)
+
+// ErrNotOwner 令牌不属于Plex服务器所有者
+var ErrNotOwner = errors.New("令牌不属于Plex服务器所有者")
+
+// 令牌是否属于所有者的校验结果
+var ownerCache tokenCache[bool]
+
+// CheckOwnerToken 校验令牌是否属于Plex服务器所有者
+// 与配置的Plex令牌一致时直接通过，否则携带该令牌请求Plex服务器的 /myplex/account 接口，
+// 该接口仅允许所有者访问，共享用户及受管用户的令牌将被拒绝
+func CheckOwnerToken(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrNotOwner
+	}
+	for _, upstream := range Upstreams() {
+		if upstream.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(upstream.Token)) == 1 {
+			return nil
+		}
+	}
+
+	owner, ok := ownerCache.get(token)
+	if !ok {
+		var err error
+		if owner, err = queryOwnerToken(ctx, token); err != nil {
+			// 连接失败不缓存，下次请求重新校验
+			return err
+		}
+		ttl := ownerRejectTTL
+		if owner {
+			ttl = ownerTokenTTL
+		}
+		ownerCache.set(token, owner, ttl)
+	}
+
+	if !owner {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// queryOwnerToken 携带令牌请求Plex服务器，判断令牌是否属于所有者
+func queryOwnerToken(ctx context.Context, token string) (bool, error) {
+	header := http.Header{}
+	header.Set("X-Plex-Token", token)
+	header.Set("Accept", "application/json")
+
+	resp, err := ProxyRequestWithBody(ctx, http.MethodGet, "/myplex/account", nil, header, nil, 0)
+	if err != nil {
+		return false, fmt.Errorf("校验Plex令牌失败: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		logging.Ctx(ctx).Debugf("Plex令牌不属于服务器所有者: %d", resp.StatusCode)
+		return false, nil
+	default:
+		return false, fmt.Errorf("校验Plex令牌失败: Plex服务器响应异常: %d", resp.StatusCode)
+	}
+}
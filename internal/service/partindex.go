@@ -0,0 +1,387 @@
+package service
+
+import (
+	"PlexWarp/constants"
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 遍历媒体库时每页获取的条目数量
+const partIndexPageSize = 500
+
+// 各分区类型遍历时使用的媒体类型：movie=电影，show=剧集，artist=音乐
+var sectionMediaTypes = map[string]string{
+	"movie":  "1",
+	"show":   "4",
+	"artist": "10",
+}
+
+const (
+	// 未找到文件的分段ID的缓存时间，避免照片等无法定位的分段每次请求都查询Plex
+	partNotFoundTTL = time.Minute
+	// 缓存的未找到文件的分段ID上限
+	partNotFoundMax = 1000
+)
+
+// 未找到文件的分段ID及过期时间
+var partNotFound = struct {
+	sync.Mutex
+	entries map[string]time.Time
+}{entries: make(map[string]time.Time)}
+
+// partEntry 分段索引条目
+type partEntry struct {
+	File      string    `json:"file"`
+	Section   string    `json:"section,omitempty"`    // 所属分区，为空表示来自实时查询
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // 过期时间，仅实时查询结果设置
+}
+
+// sectionState 分区索引状态
+type sectionState struct {
+	Signature int64     `json:"signature"` // 分区的更新/扫描时间，用于判断媒体库是否变化
+	IndexedAt time.Time `json:"indexed_at"`
+}
+
+// PartIndexStats 分段索引统计信息
+type PartIndexStats struct {
+	Entries  int    `json:"entries"`
+	Sections int    `json:"sections"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// PartIndex 分段ID到文件路径的索引
+type PartIndex struct {
+	mu       sync.RWMutex
+	entries  map[string]partEntry
+	sections map[string]sectionState
+
+	ttl         time.Duration
+	persistFile string
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+var partIndex *PartIndex
+
+// InitPartIndex 初始化分段索引并启动后台预热与刷新，仅Plex后端使用
+// 未启用 plex302 时不会查询媒体文件路径，不建立索引；之后通过热重载启用时实时查询Plex
+func InitPartIndex() {
+	cfg := config.Current()
+	if !cfg.PartIndex.Enable || !cfg.Plex302.Enable || CurrentBackend().Type() != constants.PlexServerTypePlex {
+		return
+	}
+	setting := cfg.PartIndex
+
+	partIndex = &PartIndex{
+		entries:  make(map[string]partEntry),
+		sections: make(map[string]sectionState),
+		ttl:      setting.TTL,
+	}
+	if setting.Persist {
+		partIndex.persistFile = filepath.Join(config.CacheDir, "part_index.json")
+		if err := partIndex.load(); err != nil {
+			logging.Warnf("加载分段索引缓存失败: %v", err)
+		}
+	}
+
+	go partIndex.run(setting.RefreshInterval)
+}
+
+// LookupPartFile 查询分段ID对应的文件路径，优先使用索引，未命中时实时查询Plex
+// 实时查询未找到文件时短时间内不再查询，直接返回 ErrFileNotFound
+func LookupPartFile(ctx context.Context, partID string) (string, error) {
+	if partIndex != nil {
+		if file, ok := partIndex.get(partID); ok {
+			partIndex.hits.Add(1)
+			metrics.PartLookups.WithLabelValues("hit").Inc()
+			return file, nil
+		}
+		partIndex.misses.Add(1)
+	}
+	metrics.PartLookups.WithLabelValues("miss").Inc()
+
+	if isPartNotFound(partID) {
+		return "", fmt.Errorf("%w: 分段 %s", ErrFileNotFound, partID)
+	}
+	file, err := GetPartFilePath(ctx, partID)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			markPartNotFound(partID)
+		}
+		return "", err
+	}
+	if partIndex != nil {
+		partIndex.put(partID, file)
+	}
+	return file, nil
+}
+
+// isPartNotFound 判断分段ID是否在短时间内查询过且未找到文件
+func isPartNotFound(partID string) bool {
+	partNotFound.Lock()
+	defer partNotFound.Unlock()
+	expires, ok := partNotFound.entries[partID]
+	return ok && time.Now().Before(expires)
+}
+
+// markPartNotFound 记录未找到文件的分段ID，超出上限时清理过期记录
+func markPartNotFound(partID string) {
+	partNotFound.Lock()
+	defer partNotFound.Unlock()
+	now := time.Now()
+	if len(partNotFound.entries) >= partNotFoundMax {
+		for id, expires := range partNotFound.entries {
+			if now.After(expires) {
+				delete(partNotFound.entries, id)
+			}
+		}
+		// 仍然超出上限时清空，避免大量无效的分段ID占用内存
+		if len(partNotFound.entries) >= partNotFoundMax {
+			clear(partNotFound.entries)
+		}
+	}
+	partNotFound.entries[partID] = now.Add(partNotFoundTTL)
+}
+
+// InvalidatePart 使指定分段的索引失效
+func InvalidatePart(partID string) {
+	partNotFound.Lock()
+	delete(partNotFound.entries, partID)
+	partNotFound.Unlock()
+	if partIndex == nil {
+		return
+	}
+	partIndex.mu.Lock()
+	delete(partIndex.entries, partID)
+	partIndex.mu.Unlock()
+}
+
+// GetPartIndexStats 获取分段索引统计信息
+func GetPartIndexStats() PartIndexStats {
+	if partIndex == nil {
+		return PartIndexStats{}
+	}
+	partIndex.mu.RLock()
+	defer partIndex.mu.RUnlock()
+	return PartIndexStats{
+		Entries:  len(partIndex.entries),
+		Sections: len(partIndex.sections),
+		Hits:     partIndex.hits.Load(),
+		Misses:   partIndex.misses.Load(),
+	}
+}
+
+// get 从索引中读取未过期的条目
+func (p *PartIndex) get(partID string) (string, bool) {
+	p.mu.RLock()
+	entry, ok := p.entries[partID]
+	p.mu.RUnlock()
+
+	if !ok || (!entry.ExpiresAt.IsZero() && time.Now().After(entry.ExpiresAt)) {
+		return "", false
+	}
+	return entry.File, true
+}
+
+// put 写入实时查询得到的条目
+func (p *PartIndex) put(partID, file string) {
+	entry := partEntry{File: file}
+	if p.ttl > 0 {
+		entry.ExpiresAt = time.Now().Add(p.ttl)
+	}
+	p.mu.Lock()
+	p.entries[partID] = entry
+	p.mu.Unlock()
+}
+
+// run 预热索引并按间隔刷新
+func (p *PartIndex) run(interval time.Duration) {
+	p.refresh()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.refresh()
+	}
+}
+
+// refresh 检查各分区是否变化，重新索引发生变化或索引已过期的分区
+func (p *PartIndex) refresh() {
+	container, err := GetPlexJSON(context.Background(), "/library/sections", nil)
+	if err != nil {
+		logging.Warnf("获取媒体库分区失败: %v", err)
+		return
+	}
+
+	current := make(map[string]bool)
+	changed := false
+	for _, section := range container.MediaContainer.Directory {
+		mediaType, ok := sectionMediaTypes[section.Type]
+		if !ok {
+			continue
+		}
+		current[section.Key] = true
+
+		signature := max(section.UpdatedAt, section.ScannedAt)
+		p.mu.RLock()
+		state, indexed := p.sections[section.Key]
+		p.mu.RUnlock()
+		if indexed && state.Signature == signature && (p.ttl <= 0 || time.Since(state.IndexedAt) < p.ttl) {
+			continue
+		}
+
+		count, err := p.indexSection(section.Key, mediaType, signature)
+		if err != nil {
+			logging.Warnf("索引媒体库分区失败: %s, %v", section.Title, err)
+			continue
+		}
+		changed = true
+		logging.Infof("媒体库分区索引完成: %s，共 %d 个分段", section.Title, count)
+	}
+
+	// 移除已不存在的分区
+	p.mu.Lock()
+	for key := range p.sections {
+		if !current[key] {
+			p.removeSectionLocked(key)
+			changed = true
+		}
+	}
+	p.mu.Unlock()
+
+	if changed && p.persistFile != "" {
+		if err := p.save(); err != nil {
+			logging.Warnf("保存分段索引缓存失败: %v", err)
+		}
+	}
+}
+
+// indexSection 分页遍历分区下的全部条目并替换该分区的索引
+func (p *PartIndex) indexSection(key, mediaType string, signature int64) (int, error) {
+	entries := make(map[string]partEntry)
+	for start := 0; ; start += partIndexPageSize {
+		container, err := GetPlexJSON(context.Background(), "/library/sections/"+key+"/all", url.Values{
+			"type":                   {mediaType},
+			"X-Plex-Container-Start": {strconv.Itoa(start)},
+			"X-Plex-Container-Size":  {strconv.Itoa(partIndexPageSize)},
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		for _, metadata := range container.MediaContainer.Metadata {
+			for _, media := range metadata.Media {
+				for _, part := range media.Part {
+					if part.File != "" {
+						entries[strconv.FormatInt(part.ID, 10)] = partEntry{File: part.File, Section: key}
+					}
+				}
+			}
+		}
+
+		if len(container.MediaContainer.Metadata) < partIndexPageSize {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	p.removeSectionLocked(key)
+	for id, entry := range entries {
+		p.entries[id] = entry
+	}
+	p.sections[key] = sectionState{Signature: signature, IndexedAt: time.Now()}
+	p.mu.Unlock()
+
+	return len(entries), nil
+}
+
+// removeSectionLocked 移除分区的全部索引条目，调用方需持有写锁
+func (p *PartIndex) removeSectionLocked(key string) {
+	for id, entry := range p.entries {
+		if entry.Section == key {
+			delete(p.entries, id)
+		}
+	}
+	delete(p.sections, key)
+}
+
+// partIndexFile 分段索引缓存文件结构
+type partIndexFile struct {
+	Entries  map[string]partEntry    `json:"entries"`
+	Sections map[string]sectionState `json:"sections"`
+}
+
+// load 从磁盘加载分段索引
+func (p *PartIndex) load() error {
+	data, err := os.ReadFile(p.persistFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var cache partIndexFile
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, entry := range cache.Entries {
+		if entry.Section != "" {
+			p.entries[id] = entry
+		}
+	}
+	for key, state := range cache.Sections {
+		p.sections[key] = state
+	}
+	logging.Infof("已加载分段索引缓存，共 %d 个分段", len(p.entries))
+	return nil
+}
+
+// save 将分段索引写入磁盘，实时查询结果不持久化
+func (p *PartIndex) save() error {
+	p.mu.RLock()
+	cache := partIndexFile{
+		Entries:  make(map[string]partEntry, len(p.entries)),
+		Sections: make(map[string]sectionState, len(p.sections)),
+	}
+	for id, entry := range p.entries {
+		if entry.Section != "" {
+			cache.Entries[id] = entry
+		}
+	}
+	for key, state := range p.sections {
+		cache.Sections[key] = state
+	}
+	p.mu.RUnlock()
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := p.persistFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, p.persistFile)
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlexParseMediaRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		query url.Values
+		want  *MediaRequest
+	}{
+		{
+			name: "分段文件请求",
+			path: "/library/parts/12345/1700000000/file.mkv",
+			want: &MediaRequest{PartID: "12345"},
+		},
+		{
+			name: "分段ID不是数字",
+			path: "/library/parts/abc/1700000000/file",
+		},
+		{
+			name: "转码请求",
+			path: "/video/:/transcode/universal/start.m3u8",
+			query: url.Values{
+				"path":       {"/library/metadata/678"},
+				"mediaIndex": {"1"},
+				"partIndex":  {"2"},
+			},
+			want: &MediaRequest{ItemID: "678", MediaIndex: 1, PartIndex: 2},
+		},
+		{
+			name:  "转码请求序号无效",
+			path:  "/video/:/transcode/universal/decision",
+			query: url.Values{"path": {"/library/metadata/678"}, "mediaIndex": {"x"}},
+			want:  &MediaRequest{ItemID: "678"},
+		},
+		{
+			name:  "转码请求缺少条目路径",
+			path:  "/video/:/transcode/universal/decision",
+			query: url.Values{"mediaIndex": {"0"}},
+		},
+		{
+			name: "转码分片请求",
+			path: "/video/:/transcode/universal/session/abc123/base/00001.ts",
+		},
+		{
+			name: "其他请求",
+			path: "/library/metadata/678",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := plexBackend{}.ParseMediaRequest(tt.path, tt.query)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMediaRequest(%q) = %+v，预期 %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartIndexPersist(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		entries map[string]string // 分段ID -> 文件路径
+		wantErr bool
+	}{
+		{
+			name:    "缓存文件不存在",
+			entries: map[string]string{},
+		},
+		{
+			name: "忽略实时查询结果",
+			content: `{
+				"entries": {
+					"1": {"file": "/mnt/a.mkv", "section": "2"},
+					"3": {"file": "/mnt/b.mkv", "expires_at": "2000-01-01T00:00:00Z"}
+				},
+				"sections": {"2": {"signature": 1700000000, "indexed_at": "2024-01-01T00:00:00Z"}}
+			}`,
+			entries: map[string]string{"1": "/mnt/a.mkv"},
+		},
+		{
+			name:    "格式错误",
+			content: `{"entries": [`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "part_index.json")
+			if tt.content != "" {
+				if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p := newTestPartIndex(file)
+			err := p.load()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("预期返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("加载失败: %v", err)
+			}
+			assertPartEntries(t, p, tt.entries)
+
+			// 写入后重新加载应得到相同的索引
+			p.put("9", "/mnt/live.mkv")
+			if err := p.save(); err != nil {
+				t.Fatalf("保存失败: %v", err)
+			}
+			reloaded := newTestPartIndex(file)
+			if err := reloaded.load(); err != nil {
+				t.Fatalf("重新加载失败: %v", err)
+			}
+			assertPartEntries(t, reloaded, tt.entries)
+			if !reflect.DeepEqual(reloaded.sections, p.sections) {
+				t.Errorf("分区状态为 %+v，预期 %+v", reloaded.sections, p.sections)
+			}
+		})
+	}
+}
+
+// newTestPartIndex 创建使用指定缓存文件的分段索引
+func newTestPartIndex(file string) *PartIndex {
+	return &PartIndex{
+		entries:     make(map[string]partEntry),
+		sections:    make(map[string]sectionState),
+		ttl:         time.Hour,
+		persistFile: file,
+	}
+}
+
+// assertPartEntries 检查索引中来自分区遍历的条目
+func assertPartEntries(t *testing.T, p *PartIndex, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	for id, entry := range p.entries {
+		if entry.Section != "" {
+			got[id] = entry.File
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("索引条目为 %v，预期 %v", got, want)
+	}
+}
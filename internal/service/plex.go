@@ -3,93 +3,232 @@ package service
 import (
 	"PlexWarp/internal/config"
 	"PlexWarp/internal/logging"
+	"PlexWarp/internal/metrics"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 )
 
 var (
 	PlexClient *http.Client
-	PlexBaseURL string
-	PlexToken string
+)
+
+// RouteClass 请求路由类别，不同类别使用不同的超时限制
+type RouteClass string
+
+const (
+	RouteClassAPI       RouteClass = "api"       // 普通API请求
+	RouteClassMedia     RouteClass = "media"     // 媒体文件及通知流等长连接请求
+	RouteClassTranscode RouteClass = "transcode" // 转码请求
 )
 
 // InitPlexService 初始化Plex服务
 func InitPlexService() {
+	activeBackend = backendFor(config.Current().Server.Type)
+	plexServer := config.Current().PlexServer
+	timeouts := plexServer.Timeouts
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeouts.Dial,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   timeouts.TLSHandshake,
+		ResponseHeaderTimeout: timeouts.ResponseHeader,
+		IdleConnTimeout:       timeouts.IdleConn,
+		MaxIdleConns:          plexServer.MaxIdleConns,
+		MaxIdleConnsPerHost:   plexServer.MaxIdleConns,
+		// 原样转发 Accept-Encoding，避免透明解压导致 Content-Length 和 Content-Range 与响应体不一致
+		DisableCompression: true,
+	}
+
+	// 不设置总超时，由各请求按路由类别通过 context 控制
 	PlexClient = &http.Client{
-		Timeout: 30 * time.Second,
+		Transport: transport,
+		// 重定向响应原样返回给客户端，由客户端自行跟随
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
 	}
 
-	PlexBaseURL = strings.TrimSuffix(config.PlexServer.ADDR, "/")
-	PlexToken = config.PlexServer.AUTH
+	for _, upstream := range Upstreams() {
+		logging.Infof("Plex服务初始化完成，服务器 %s 地址: %s，优先级: %d", upstream.Name, upstream.BaseURL, upstream.Priority)
+	}
+}
 
-	logging.Infof("Plex服务初始化完成，服务器地址: %s", PlexBaseURL)
+// PlexRequestURL 构建转发客户端请求的Plex URL，按路由规则及健康状态选择服务器
+// 不附加配置的令牌，未携带令牌的客户端请求由Plex服务器按匿名请求处理
+func PlexRequestURL(path string, query url.Values, header http.Header) string {
+	return SelectUpstreams(path, query, header)[0].URL(path, query, false)
 }
 
-// BuildPlexURL 构建Plex URL
-func BuildPlexURL(path string, params map[string]string) string {
-	u, err := url.Parse(PlexBaseURL + path)
-	if err != nil {
-		logging.Errorf("解析URL失败: %v", err)
-		return ""
+// ClassifyRoute 根据请求路径判断路由类别
+func ClassifyRoute(path string) RouteClass {
+	return CurrentBackend().ClassifyRoute(path)
+}
+
+// RouteTimeout 获取路由类别对应的无响应时长限制，0表示不限制
+func RouteTimeout(class RouteClass) time.Duration {
+	timeouts := config.Current().PlexServer.Timeouts
+	switch class {
+	case RouteClassMedia:
+		return timeouts.Media
+	case RouteClassTranscode:
+		return timeouts.Transcode
+	default:
+		return timeouts.API
 	}
+}
+
+// routeTimerKey 在 context 中保存无响应计时器的键
+type routeTimerKey struct{}
+
+// routeTimer 无响应计时器，超时后取消请求
+type routeTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
 
-	q := u.Query()
-	if PlexToken != "" {
-		q.Set("X-Plex-Token", PlexToken)
+// RouteContext 为请求路径创建带有对应超时限制的 context
+// 超时限制的是无响应的时长：发送请求体或读取响应体时超过时长没有新数据，或请求发送完毕后
+// 超过时长未收到响应头时取消请求，持续传输数据的请求（如上传海报、导出大量元数据）不受总时长限制
+func RouteContext(parent context.Context, path string) (context.Context, context.CancelFunc) {
+	timeout := RouteTimeout(ClassifyRoute(path))
+	if timeout <= 0 {
+		return context.WithCancel(parent)
 	}
 
-	for key, value := range params {
-		q.Set(key, value)
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := &routeTimer{timeout: timeout}
+	timer.timer = time.AfterFunc(timeout, func() {
+		cancel(fmt.Errorf("超过 %s 未收到Plex响应: %w", timeout, context.DeadlineExceeded))
+	})
+	ctx = context.WithValue(ctx, routeTimerKey{}, timer)
+	return ctx, func() {
+		timer.timer.Stop()
+		cancel(context.Canceled)
 	}
+}
+
+// reset 重新计时
+func (t *routeTimer) reset() {
+	t.timer.Reset(t.timeout)
+}
 
-	u.RawQuery = q.Encode()
-	return u.String()
+// idleReader 每次读取到数据时重新计时
+type idleReader struct {
+	io.Reader
+	timer *routeTimer
 }
 
-// ProxyRequest 代理请求到Plex服务器
-func ProxyRequest(method, path string, params map[string]string, headers map[string]string) (*http.Response, error) {
-	plexURL := BuildPlexURL(path, params)
-	if plexURL == "" {
-		return nil, fmt.Errorf("构建Plex URL失败")
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.timer.reset()
 	}
+	return n, err
+}
 
-	req, err := http.NewRequest(method, plexURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("创建请求失败: %v", err)
+// idleBody 响应体，每次读取到数据时重新计时
+type idleBody struct {
+	idleReader
+	io.Closer
+}
+
+// withIdleRequestBody 发送请求体时持续计时，上传期间不会因等待响应而超时
+func withIdleRequestBody(ctx context.Context, body io.Reader) io.Reader {
+	timer, ok := ctx.Value(routeTimerKey{}).(*routeTimer)
+	if !ok || body == nil {
+		return body
 	}
+	return &idleReader{Reader: body, timer: timer}
+}
 
-	// 设置请求头
-	for key, value := range headers {
-		req.Header.Set(key, value)
+// withIdleTimeout 收到响应头后重新计时，并在读取响应体时持续计时
+func withIdleTimeout(ctx context.Context, resp *http.Response) {
+	timer, ok := ctx.Value(routeTimerKey{}).(*routeTimer)
+	if !ok {
+		return
 	}
+	timer.reset()
+	resp.Body = &idleBody{idleReader: idleReader{Reader: resp.Body, timer: timer}, Closer: resp.Body}
+}
+
+// ProxyRequestWithBody PlexWarp自身向Plex服务器发送请求，未携带令牌时附加配置的令牌
+// 请求体以流式方式转发，contentLength 为-1时表示长度未知，将使用分块传输
+// 连接失败时将服务器标记为不可用，没有请求体的请求依次尝试其余服务器
+func ProxyRequestWithBody(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	return sendRequest(ctx, method, path, query, header, body, contentLength, true)
+}
 
-	// 设置默认请求头
-	req.Header.Set("User-Agent", "PlexWarp/1.0")
-	req.Header.Set("Accept", "application/json")
+// ForwardRequest 将客户端请求转发到Plex服务器，不附加配置的令牌，
+// 避免未携带令牌的客户端以服务器所有者的身份访问
+func ForwardRequest(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	return sendRequest(ctx, method, path, query, header, body, contentLength, false)
+}
 
-	resp, err := PlexClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("请求失败: %v", err)
+// sendRequest 按候选顺序向Plex服务器发送请求，withToken 为 true 时附加配置的令牌
+func sendRequest(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader, contentLength int64, withToken bool) (*http.Response, error) {
+	// 没有请求体时不传递 Body，避免无请求体的请求被当作分块传输
+	if contentLength == 0 {
+		body = nil
+	}
+	body = withIdleRequestBody(ctx, body)
+
+	candidates := SelectUpstreams(path, query, header)
+	class := string(ClassifyRoute(path))
+	var lastErr error
+	for i, upstream := range candidates {
+		start := time.Now()
+		resp, err := upstream.do(ctx, method, path, query, header, body, contentLength, withToken)
+		if err == nil {
+			withIdleTimeout(ctx, resp)
+			metrics.UpstreamDuration.WithLabelValues(upstream.Name, class).Observe(time.Since(start).Seconds())
+			return resp, nil
+		}
+		lastErr = err
+
+		// 客户端取消或请求超时不代表服务器不可用
+		if ctx.Err() != nil {
+			break
+		}
+		metrics.UpstreamErrors.WithLabelValues(upstream.Name).Inc()
+		upstream.markHealth(err)
+
+		// 请求体可能已被部分读取，无法重试
+		if body != nil || i == len(candidates)-1 {
+			break
+		}
+		logging.Ctx(ctx).Warnf("Plex服务器 %s 请求失败，切换到 %s: %v", upstream.Name, candidates[i+1].Name, err)
 	}
 
-	return resp, nil
+	return nil, fmt.Errorf("请求失败: %v", lastErr)
 }
 
-// CheckPlexConnection 检查Plex连接
+// CheckPlexConnection 检查全部Plex服务器的连接，至少一台可用时返回 nil
 func CheckPlexConnection() error {
-	resp, err := ProxyRequest("GET", "/", nil, nil)
-	if err != nil {
-		return fmt.Errorf("连接Plex服务器失败: %v", err)
+	var firstErr error
+	available := false
+	for _, upstream := range Upstreams() {
+		err := upstream.CheckConnection()
+		upstream.markHealth(err)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logging.Infof("Plex服务器 %s 连接正常", upstream.Name)
+		available = true
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Plex服务器响应异常: %d", resp.StatusCode)
+	if available {
+		return nil
 	}
-
-	logging.Info("Plex服务器连接正常")
-	return nil
+	return firstErr
 }
\ No newline at end of file
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"PlexWarp/internal/logging"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// plex.tv 账号信息接口
+	plexAccountURL = "https://plex.tv/api/v2/user"
+	// 请求 plex.tv 的超时时间
+	plexAccountTimeout = 10 * time.Second
+	// 用户名查询结果的缓存时间
+	plexUserTTL = time.Hour
+	// 查询失败的缓存时间，避免 plex.tv 不可用时每个请求都重新查询
+	plexUserFailureTTL = time.Minute
+)
+
+var (
+	// 令牌对应的Plex用户名，查询失败时为空
+	plexUserCache tokenCache[string]
+
+	plexAccountClient = &http.Client{Timeout: plexAccountTimeout}
+)
+
+// PlexUsername 获取Plex令牌所属账号的用户名，查询结果缓存一小时
+// 令牌无效或 plex.tv 不可用时返回错误，失败结果同样缓存一分钟
+func PlexUsername(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("未携带Plex令牌")
+	}
+	if username, ok := plexUserCache.get(token); ok {
+		if username == "" {
+			return "", fmt.Errorf("Plex令牌无效或 plex.tv 暂时不可用")
+		}
+		return username, nil
+	}
+
+	username, err := queryPlexUsername(ctx, token)
+	if err != nil {
+		logging.Ctx(ctx).Warnf("查询Plex用户名失败: %v", err)
+		plexUserCache.set(token, "", plexUserFailureTTL)
+		return "", err
+	}
+	plexUserCache.set(token, username, plexUserTTL)
+	return username, nil
+}
+
+// queryPlexUsername 请求 plex.tv 获取令牌所属账号的用户名
+func queryPlexUsername(ctx context.Context, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, plexAccountURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Plex-Token", token)
+	req.Header.Set("X-Plex-Product", "PlexWarp")
+	req.Header.Set("X-Plex-Client-Identifier", "PlexWarp")
+
+	resp, err := plexAccountClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("请求 plex.tv 失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("plex.tv 响应异常: %d", resp.StatusCode)
+	}
+
+	var account struct {
+		Username string `json:"username"`
+		Title    string `json:"title"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return "", fmt.Errorf("解析 plex.tv 响应失败: %v", err)
+	}
+	// 受管用户没有用户名，使用显示名称
+	if account.Username == "" {
+		return account.Title, nil
+	}
+	return account.Username, nil
+}
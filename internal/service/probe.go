@@ -0,0 +1,160 @@
+package service
+
+import (
+	"PlexWarp/internal/logging"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	probeTimeout    = 10 * time.Second // 单次探测超时
+	probeCacheTTL   = time.Minute      // 探测结果缓存时长
+	probeHistoryMax = 100              // 保留的最近探测记录数量
+	probeCacheMax   = 1000             // 缓存的探测结果数量上限
+)
+
+// ProbeResult 链接有效性探测结果
+type ProbeResult struct {
+	Link       string    `json:"link"`
+	Valid      bool      `json:"valid"`
+	Method     string    `json:"method"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	LatencyMs  int64     `json:"latency_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Cached     bool      `json:"cached"`
+}
+
+// linkProber 链接探测器，缓存探测结果并保留最近的探测记录
+type linkProber struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	cache   map[string]ProbeResult
+	history []ProbeResult
+}
+
+var prober = &linkProber{
+	client: &http.Client{Timeout: probeTimeout},
+	cache:  make(map[string]ProbeResult),
+}
+
+// ProbeLink 探测链接是否可用，先发送HEAD请求，失败时回退为只获取1字节的GET请求
+func ProbeLink(ctx context.Context, link string) ProbeResult {
+	prober.mu.Lock()
+	if cached, ok := prober.cache[link]; ok && time.Since(cached.CheckedAt) < probeCacheTTL {
+		prober.mu.Unlock()
+		cached.Cached = true
+		return cached
+	}
+	prober.mu.Unlock()
+
+	start := time.Now()
+	result := prober.probe(ctx, http.MethodHead, link)
+	if !result.Valid {
+		result = prober.probe(ctx, http.MethodGet, link)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.CheckedAt = time.Now()
+
+	if result.Valid {
+		logging.Ctx(ctx).Infof("链接探测成功: %s %s %d (%dms)", result.Method, link, result.StatusCode, result.LatencyMs)
+	} else {
+		logging.Ctx(ctx).Warnf("链接探测失败: %s %s %d %s (%dms)", result.Method, link, result.StatusCode, result.Error, result.LatencyMs)
+	}
+
+	prober.mu.Lock()
+	prober.prune(result.CheckedAt)
+	prober.cache[link] = result
+	prober.history = append(prober.history, result)
+	if len(prober.history) > probeHistoryMax {
+		prober.history = prober.history[len(prober.history)-probeHistoryMax:]
+	}
+	prober.mu.Unlock()
+
+	return result
+}
+
+// RecentProbes 获取最近的探测记录，按时间倒序排列
+func RecentProbes() []ProbeResult {
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+
+	results := make([]ProbeResult, 0, len(prober.history))
+	for i := len(prober.history) - 1; i >= 0; i-- {
+		results = append(results, prober.history[i])
+	}
+	return results
+}
+
+// ProbeStats 链接探测缓存统计信息
+type ProbeStats struct {
+	Cached  int `json:"cached"`  // 未过期的探测结果数量
+	History int `json:"history"` // 保留的探测记录数量
+}
+
+// GetProbeStats 获取链接探测缓存统计信息
+func GetProbeStats() ProbeStats {
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	stats := ProbeStats{History: len(prober.history)}
+	for _, cached := range prober.cache {
+		if time.Since(cached.CheckedAt) < probeCacheTTL {
+			stats.Cached++
+		}
+	}
+	return stats
+}
+
+// prune 缓存达到上限时清理过期的探测结果，仍然达到上限时移除最早的结果，调用方需持有锁
+func (p *linkProber) prune(now time.Time) {
+	if len(p.cache) < probeCacheMax {
+		return
+	}
+	for link, cached := range p.cache {
+		if now.Sub(cached.CheckedAt) >= probeCacheTTL {
+			delete(p.cache, link)
+		}
+	}
+	for len(p.cache) >= probeCacheMax {
+		var oldest string
+		for link, cached := range p.cache {
+			if oldest == "" || cached.CheckedAt.Before(p.cache[oldest].CheckedAt) {
+				oldest = link
+			}
+		}
+		delete(p.cache, oldest)
+	}
+}
+
+// probe 使用指定方法探测链接
+func (p *linkProber) probe(ctx context.Context, method, link string) ProbeResult {
+	result := ProbeResult{Link: link, Method: method}
+
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	req.Header.Set("User-Agent", "PlexWarp/1.0")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if method == http.MethodHead {
+		result.Valid = resp.StatusCode < http.StatusBadRequest
+	} else {
+		result.Valid = resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent
+	}
+	return result
+}
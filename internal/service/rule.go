@@ -0,0 +1,78 @@
+package service
+
+import (
+	"PlexWarp/constants"
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// LinkRuleMatch 最终链接规则的匹配结果
+type LinkRuleMatch struct {
+	Index   int                     `json:"index"`   // 命中的规则序号
+	Rule    config.StrmRedirectRule `json:"rule"`    // 命中的规则
+	Pattern string                  `json:"pattern"` // 命中的匹配模式
+}
+
+// 已编译的正则表达式缓存
+var linkRegexCache sync.Map
+
+// MatchLastLinkRule 按顺序对最终链接执行规则匹配，返回第一条命中的规则
+func MatchLastLinkRule(link string) *LinkRuleMatch {
+	for i, rule := range config.Current().StrmRedirect.LastLinkRules {
+		for _, pattern := range rule.Patterns {
+			if matchLinkPattern(rule.MatchType, pattern, link) {
+				return &LinkRuleMatch{Index: i, Rule: rule, Pattern: pattern}
+			}
+		}
+	}
+	return nil
+}
+
+// LinkAction 获取最终链接的处理动作，未命中任何规则时默认重定向
+func LinkAction(link string) (string, *LinkRuleMatch) {
+	if !config.Current().StrmRedirect.Enable {
+		return constants.STRM_ACTION_REDIRECT, nil
+	}
+
+	match := MatchLastLinkRule(link)
+	if match == nil {
+		return constants.STRM_ACTION_REDIRECT, nil
+	}
+	return strings.ToLower(match.Rule.Action), match
+}
+
+// matchLinkPattern 判断链接是否匹配指定模式
+func matchLinkPattern(matchType, pattern, link string) bool {
+	switch strings.ToLower(matchType) {
+	case constants.MATCH_TYPE_STARTSWITH:
+		return strings.HasPrefix(link, pattern)
+	case constants.MATCH_TYPE_ENDSWITH:
+		return strings.HasSuffix(link, pattern)
+	case constants.MATCH_TYPE_CONTAINS:
+		return strings.Contains(link, pattern)
+	case constants.MATCH_TYPE_REGEX:
+		re := compileLinkRegex(pattern)
+		return re != nil && re.MatchString(link)
+	default:
+		return false
+	}
+}
+
+// compileLinkRegex 编译并缓存正则表达式，无效的表达式返回 nil
+func compileLinkRegex(pattern string) *regexp.Regexp {
+	if cached, ok := linkRegexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+
+	// 无效的表达式同样缓存，避免每次请求重复输出警告
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		logging.Warnf("无效的正则表达式: %s, %v", pattern, err)
+		re = nil
+	}
+	linkRegexCache.Store(pattern, re)
+	return re
+}
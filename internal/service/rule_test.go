@@ -0,0 +1,34 @@
+package service
+
+import "testing"
+
+func TestMatchLinkPattern(t *testing.T) {
+	const link = "https://cdn.example.com/media/Movie (2024).mkv?sign=abc"
+
+	tests := []struct {
+		name      string
+		matchType string
+		pattern   string
+		want      bool
+	}{
+		{"前缀匹配", "startswith", "https://cdn.example.com/", true},
+		{"前缀不匹配", "startswith", "http://cdn.example.com/", false},
+		{"匹配类型不区分大小写", "StartsWith", "https://cdn", true},
+		{"后缀匹配", "endswith", "?sign=abc", true},
+		{"后缀不匹配", "endswith", ".mkv", false},
+		{"包含", "contains", "/media/", true},
+		{"包含区分大小写", "contains", "/MEDIA/", false},
+		{"正则匹配", "regex", `^https://cdn\.[^/]+/media/.*\.mkv`, true},
+		{"正则不匹配", "regex", `\.mp4$`, false},
+		{"无效的正则表达式", "regex", `(`, false},
+		{"未知的匹配类型", "glob", "*", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchLinkPattern(tt.matchType, tt.pattern, link); got != tt.want {
+				t.Errorf("matchLinkPattern(%q, %q) = %v，预期 %v", tt.matchType, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/metrics"
+	"PlexWarp/utils"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// 代理远程媒体流时转发给源站的客户端请求头
+var streamForwardHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-Modified-Since",
+	"If-None-Match",
+	"User-Agent",
+	"Accept",
+	"Accept-Encoding",
+}
+
+// ProxyStream 通过PlexWarp反向代理远程媒体链接，支持范围请求
+// 仅转发与媒体传输相关的请求头，避免将Plex令牌等信息泄露给源站
+// 源站的重定向由PlexWarp跟随，适用于仅局域网可访问的源站；请求失败或源站返回错误状态码时
+// 返回错误且不写入响应，调用方可以尝试其他链接
+func ProxyStream(w http.ResponseWriter, r *http.Request, link string) error {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	method := http.MethodGet
+	if r.Method == http.MethodHead {
+		method = http.MethodHead
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	for _, key := range streamForwardHeaders {
+		if values := r.Header.Values(key); len(values) > 0 {
+			req.Header[key] = values
+		}
+	}
+
+	// 复用Plex连接池的传输设置，由PlexWarp跟随源站的重定向
+	client := &http.Client{Transport: PlexClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 范围超出文件大小属于客户端请求的问题，其余错误状态码视为源站不可用
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return fmt.Errorf("源站响应异常: %d", resp.StatusCode)
+	}
+
+	// 以源站的取值替换中间件设置的同名响应头
+	for key, values := range resp.Header {
+		if !utils.IsHopByHopHeader(key) {
+			w.Header().Del(key)
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// 响应头已写出，之后的错误无法再回退，仅记录日志
+	if method == http.MethodHead {
+		return nil
+	}
+	writer, done := TrackSession(w, r, metrics.SourceDirect)
+	defer done()
+	n, err := io.Copy(writer, resp.Body)
+	metrics.BytesStreamed.WithLabelValues(metrics.SourceDirect).Add(float64(n))
+	if err != nil && r.Context().Err() == nil {
+		logging.Ctx(r.Context()).Warnf("代理媒体流中断: %s, %v", link, err)
+	}
+	return nil
+}
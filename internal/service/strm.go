@@ -1,13 +1,19 @@
 package service
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"PlexWarp/constants"
 	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/metrics"
 )
 
 // StrmService strm文件处理服务
@@ -24,11 +30,8 @@ func (s *StrmService) IsStrmFile(filePath string) bool {
 	return strings.HasSuffix(strings.ToLower(filePath), ".strm")
 }
 
-// ReadStrmContent 读取strm文件内容
-func (s *StrmService) ReadStrmContent(filePath string) (string, error) {
-	// 应用路径映射
-	mappedPath := s.applyPathMapping(filePath)
-	
+// readStrmFile 读取已完成路径映射的strm文件内容
+func (s *StrmService) readStrmFile(mappedPath string) (string, error) {
 	// 检查文件是否存在
 	if _, err := os.Stat(mappedPath); os.IsNotExist(err) {
 		return "", fmt.Errorf("strm file not found: %s", mappedPath)
@@ -44,59 +47,195 @@ func (s *StrmService) ReadStrmContent(filePath string) (string, error) {
 	if content == "" {
 		return "", fmt.Errorf("strm file is empty")
 	}
-
-	log.Printf("Read strm content: %s -> %s", filePath, content)
 	return content, nil
 }
 
-// GetDirectLinkFromStrm 从strm文件获取直链
-func (s *StrmService) GetDirectLinkFromStrm(strmPath string) (string, error) {
-	// 读取strm文件内容
-	content, err := s.ReadStrmContent(strmPath)
-	if err != nil {
-		return "", err
+// strmLines 获取strm文件内容中的候选行，跳过空行及以#开头的注释行
+func strmLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
 	}
+	return lines
+}
 
+// resolveStrmLine 解析strm文件中的一行内容
+func (s *StrmService) resolveStrmLine(ctx context.Context, content string) (string, error) {
 	// 如果内容已经是HTTP链接，直接返回
-	if strings.HasPrefix(content, "http://") || strings.HasPrefix(content, "https://") {
-		log.Printf("Strm contains direct HTTP link: %s", content)
+	if IsHTTPLink(content) {
+		logging.Ctx(ctx).Debugf("Strm contains direct HTTP link: %s", content)
 		return content, nil
 	}
 
-	// 对于本地路径，当前版本不支持，返回错误
+	// 本地路径先应用路径映射，映射结果可能是HTTP链接或本地路径
 	if strings.HasPrefix(content, "/") {
-		return "", fmt.Errorf("local path not supported in current version: %s", content)
+		mappedPath := s.applyPathMapping(ctx, content)
+		if IsHTTPLink(mappedPath) {
+			return mappedPath, nil
+		}
+
+		resolved, err := s.checkLocalPath(mappedPath)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return "", fmt.Errorf("local media file not accessible: %s, %v", mappedPath, err)
+		}
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("local media path is not a regular file: %s", mappedPath)
+		}
+		return resolved, nil
 	}
 
 	return "", fmt.Errorf("unsupported strm content format: %s", content)
 }
 
-// applyPathMapping 应用路径映射规则
-func (s *StrmService) applyPathMapping(path string) string {
+// checkLocalPath 检查本地文件是否位于允许直接提供的目录中，返回解析软链接后的实际路径
+// 允许的目录为 plex302.media_mount_paths 及路径映射、软链接规则的本地目标路径，避免strm内容指向任意文件
+func (s *StrmService) checkLocalPath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", fmt.Errorf("local media file not accessible: %s, %v", path, err)
+	}
+
+	for _, root := range localRoots() {
+		if realRoot, err := filepath.EvalSymlinks(root); err == nil {
+			root = realRoot
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrForbiddenPath, path)
+}
+
+// localRoots 获取允许直接提供本地文件的目录
+func localRoots() []string {
+	cfg := config.Current()
+	roots := append([]string{}, cfg.Plex302.MediaMountPaths...)
+	for _, rule := range cfg.PathMapping.Rules {
+		roots = append(roots, rule.To)
+	}
+	for _, rule := range cfg.Symlink.Rules {
+		roots = append(roots, rule.Target)
+	}
+
+	result := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if filepath.IsAbs(root) {
+			result = append(result, filepath.Clean(root))
+		}
+	}
+	return result
+}
+
+// SelectValidLink 从候选直链中选出第一个可用的链接
+// 未启用链接有效性检查时直接返回第一个候选，本地文件在解析时已确认存在
+func (s *StrmService) SelectValidLink(ctx context.Context, links []string) (string, error) {
+	if !config.Current().Plex302.CheckLinkValidity {
+		return links[0], nil
+	}
+
+	for _, link := range links {
+		if !IsHTTPLink(link) {
+			return link, nil
+		}
+		if result := ProbeLink(ctx, link); result.Valid {
+			return link, nil
+		}
+	}
+	return "", fmt.Errorf("no valid link among %d candidates", len(links))
+}
+
+// IsHTTPLink 判断是否为HTTP链接
+func IsHTTPLink(link string) bool {
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://")
+}
+
+// ServeLocalFile 直接提供本地媒体文件，支持范围请求及HEAD请求
+func (s *StrmService) ServeLocalFile(w http.ResponseWriter, r *http.Request, filePath string) error {
+	filePath, err := s.checkLocalPath(filePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("open local media file failed: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local media file failed: %v", err)
+	}
+
+	writer, done := TrackSession(w, r, metrics.SourceLocal)
+	defer done()
+	http.ServeContent(writer, r, filepath.Base(filePath), info.ModTime(), file)
+	if sized, ok := w.(interface{ Size() int }); ok && sized.Size() > 0 {
+		metrics.BytesStreamed.WithLabelValues(metrics.SourceLocal).Add(float64(sized.Size()))
+	}
+	return nil
+}
+
+// PathMappingResult 路径映射结果
+type PathMappingResult struct {
+	Original  string `json:"original"`   // 原始路径
+	Mapped    string `json:"mapped"`     // 映射后的路径，未命中规则时与原始路径相同
+	RuleType  string `json:"rule_type"`  // 命中的规则类型：symlink、path_mapping，未命中为空
+	RuleIndex int    `json:"rule_index"` // 命中的规则序号，未命中为-1
+}
+
+// MapPath 按软链接规则、路径映射规则的顺序映射路径，第一条命中的规则生效
+func (s *StrmService) MapPath(path string) PathMappingResult {
+	cfg := config.Current()
+	result := PathMappingResult{Original: path, Mapped: path, RuleIndex: -1}
+
 	// 首先检查软链接规则
-	for _, rule := range config.Symlink.Rules {
-		if strings.HasPrefix(path, rule.Path) {
-			mappedPath := strings.Replace(path, rule.Path, rule.Target, 1)
-			log.Printf("Applied symlink rule: %s -> %s", path, mappedPath)
-			return mappedPath
+	if cfg.Symlink.Enable {
+		for i, rule := range cfg.Symlink.Rules {
+			if strings.HasPrefix(path, rule.Path) {
+				result.Mapped = strings.Replace(path, rule.Path, rule.Target, 1)
+				result.RuleType, result.RuleIndex = "symlink", i
+				return result
+			}
 		}
 	}
 
 	// 然后应用媒体路径映射规则
-	for _, rule := range config.PathMapping.Rules {
-		if strings.HasPrefix(path, rule.From) {
-			mappedPath := strings.Replace(path, rule.From, rule.To, 1)
-			log.Printf("Applied path mapping: %s -> %s", path, mappedPath)
-			return mappedPath
+	if cfg.PathMapping.Enable {
+		for i, rule := range cfg.PathMapping.Rules {
+			if strings.HasPrefix(path, rule.From) {
+				result.Mapped = strings.Replace(path, rule.From, rule.To, 1)
+				result.RuleType, result.RuleIndex = "path_mapping", i
+				return result
+			}
 		}
 	}
 
-	return path
+	return result
+}
+
+// applyPathMapping 应用路径映射规则
+func (s *StrmService) applyPathMapping(ctx context.Context, path string) string {
+	result := s.MapPath(path)
+	switch result.RuleType {
+	case "symlink":
+		logging.Ctx(ctx).Infof("Applied symlink rule: %s -> %s", path, result.Mapped)
+	case "path_mapping":
+		logging.Ctx(ctx).Infof("Applied path mapping: %s -> %s", path, result.Mapped)
+	}
+	return result.Mapped
 }
 
 // IsMediaPath 判断路径是否在媒体挂载路径中
 func (s *StrmService) IsMediaPath(path string) bool {
-	for _, mountPath := range config.Plex302.MediaMountPaths {
+	for _, mountPath := range config.Current().Plex302.MediaMountPaths {
 		if strings.HasPrefix(path, mountPath) {
 			return true
 		}
@@ -105,24 +244,25 @@ func (s *StrmService) IsMediaPath(path string) bool {
 }
 
 // ShouldRedirect 判断是否应该进行302重定向
-func (s *StrmService) ShouldRedirect(path string, userAgent string) bool {
+// filePath 为媒体文件在Plex服务器上的实际路径，requestPath 为客户端请求路径
+func (s *StrmService) ShouldRedirect(filePath string, requestPath string) bool {
 	// 检查功能是否启用
-	if !config.Plex302.Enable {
+	if !config.Current().Plex302.Enable {
 		return false
 	}
 
 	// 检查是否为strm文件
-	if !s.IsStrmFile(path) {
+	if !s.IsStrmFile(filePath) {
 		return false
 	}
 
 	// 检查是否在媒体路径中
-	if !s.IsMediaPath(path) {
+	if !s.IsMediaPath(filePath) {
 		return false
 	}
 
 	// 检查是否为转码请求（如果禁用转码重定向）
-	if !config.Plex302.TranscodeEnable && s.isTranscodeRequest(path) {
+	if !config.Current().Plex302.TranscodeEnable && s.isTranscodeRequest(requestPath) {
 		return false
 	}
 
@@ -131,57 +271,106 @@ func (s *StrmService) ShouldRedirect(path string, userAgent string) bool {
 
 // isTranscodeRequest 判断是否为转码请求
 func (s *StrmService) isTranscodeRequest(path string) bool {
-	// 检查路径中是否包含转码相关的关键词
-	transcodeKeywords := []string{
-		"transcode",
-		"universal",
-		"decision",
-		"start",
+	return CurrentBackend().IsTranscodePath(path)
+}
+
+// HandleRedirect 处理302重定向
+// 需要回退到原始请求时返回的错误包含 ErrFallback
+func (s *StrmService) HandleRedirect(w http.ResponseWriter, r *http.Request, strmPath string) error {
+	// 获取直链及处理动作，启用有效性检查时依次尝试候选链接
+	started := time.Now()
+	decision := s.Resolve(r.Context(), strmPath, r.URL.Path)
+	directLink := decision.Link
+	logger := logging.Ctx(r.Context())
+
+	// 按最终结果统计，代理失败时记为回退或错误
+	action := decision.Action
+	defer func() {
+		metrics.RedirectDecisions.WithLabelValues(action).Inc()
+		recordDecision(r, started, decision, action)
+	}()
+
+	switch decision.Action {
+	case DecisionPassthrough:
+		return ErrFallback
+	case DecisionFallback:
+		logger.Warnf("Failed to get direct link for strm: %v", decision.Err())
+		logger.Infof("Falling back to original request")
+		return fmt.Errorf("%w: %v", ErrFallback, decision.Err())
+	case DecisionError:
+		logger.Errorf("Failed to get direct link for strm: %v", decision.Err())
+		return decision.Err()
+	case DecisionLocal:
+		// 本地文件由PlexWarp直接提供
+		logger.Infof("Serving local media file: %s", directLink)
+		return s.ServeLocalFile(w, r, directLink)
 	}
 
-	pathLower := strings.ToLower(path)
-	for _, keyword := range transcodeKeywords {
-		if strings.Contains(pathLower, keyword) {
-			return true
-		}
+	if match := decision.Rule; match != nil {
+		logger.Infof("最终链接命中规则 #%d (%s %s): %s -> %s", match.Index+1, match.Rule.MatchType, match.Pattern, directLink, decision.Action)
 	}
 
-	return false
+	switch decision.Action {
+	case DecisionProxy:
+		if err := s.proxyLinks(w, r, decision); err != nil {
+			if config.Current().Plex302.FallbackOriginal {
+				action = DecisionFallback
+				return fmt.Errorf("%w: %v", ErrFallback, err)
+			}
+			action = DecisionError
+			return err
+		}
+		return nil
+	default:
+		// 执行302重定向
+		logger.Infof("Redirecting to direct link: %s", directLink)
+		w.Header().Set("Location", directLink)
+		w.WriteHeader(http.StatusFound)
+		return nil
+	}
 }
 
-// HandleRedirect 处理302重定向
-func (s *StrmService) HandleRedirect(w http.ResponseWriter, r *http.Request, strmPath string) error {
-	// 获取直链
-	directLink, err := s.GetDirectLinkFromStrm(strmPath)
-	if err != nil {
-		log.Printf("Failed to get direct link for strm: %v", err)
-		
-		// 如果启用了回退到原始链接
-		if config.Plex302.FallbackOriginal {
-			log.Printf("Falling back to original request")
-			return fmt.Errorf("fallback to original")
-		}
-		
-		return err
+// proxyLinks 代理选中的链接，源站不可用时依次尝试其后同样需要代理的候选链接
+func (s *StrmService) proxyLinks(w http.ResponseWriter, r *http.Request, decision *RedirectDecision) error {
+	logger := logging.Ctx(r.Context())
+	links := []string{decision.Link}
+	if i := slices.Index(decision.Links, decision.Link); i >= 0 {
+		links = decision.Links[i:]
 	}
 
-	// 执行302重定向
-	log.Printf("Redirecting to direct link: %s", directLink)
-	w.Header().Set("Location", directLink)
-	w.WriteHeader(http.StatusFound)
-	return nil
+	var lastErr error
+	for i, link := range links {
+		if i > 0 {
+			if !IsHTTPLink(link) {
+				continue
+			}
+			if action, _ := LinkAction(link); action != constants.STRM_ACTION_PROXY {
+				continue
+			}
+		}
+		logger.Infof("Proxying direct link: %s", link)
+		err := ProxyStream(w, r, link)
+		if err == nil {
+			decision.Link = link
+			return nil
+		}
+		logger.Warnf("Proxy direct link failed: %s, %v", link, err)
+		decision.step("代理失败", "%s: %v", link, err)
+		lastErr = err
+	}
+	return lastErr
 }
 
 // CheckStrmHealth 检查strm相关服务健康状态
 func (s *StrmService) CheckStrmHealth() error {
-	if !config.Plex302.Enable {
+	if !config.Current().Plex302.Enable {
 		return nil // 功能未启用，跳过检查
 	}
 
 	// 检查媒体挂载路径
-	for _, mountPath := range config.Plex302.MediaMountPaths {
+	for _, mountPath := range config.Current().Plex302.MediaMountPaths {
 		if _, err := os.Stat(mountPath); os.IsNotExist(err) {
-			log.Printf("Warning: media mount path does not exist: %s", mountPath)
+			logging.Warnf("Media mount path does not exist: %s", mountPath)
 		}
 	}
 
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// 缓存的令牌查询结果上限，超出时清理过期结果
+const tokenCacheMax = 1000
+
+// tokenCache 以令牌为键的查询结果缓存
+// 以令牌的SHA-256为键，避免在内存中保留原始令牌
+type tokenCache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]tokenCacheEntry[T]
+}
+
+type tokenCacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// tokenKey 获取令牌的缓存键
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// get 获取未过期的查询结果
+func (c *tokenCache[T]) get(token string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[tokenKey(token)]
+	if !ok || time.Now().After(entry.expires) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set 缓存查询结果
+func (c *tokenCache[T]) set(token string, value T, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]tokenCacheEntry[T])
+	}
+	if len(c.entries) >= tokenCacheMax {
+		now := time.Now()
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		// 仍然超出上限时清空，避免大量无效令牌占用内存
+		if len(c.entries) >= tokenCacheMax {
+			c.entries = make(map[string]tokenCacheEntry[T])
+		}
+	}
+	c.entries[tokenKey(token)] = tokenCacheEntry[T]{value: value, expires: time.Now().Add(ttl)}
+}
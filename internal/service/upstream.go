@@ -0,0 +1,365 @@
+package service
+
+import (
+	"PlexWarp/constants"
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/logging"
+	"PlexWarp/internal/metrics"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认健康检查间隔，配置无效时使用
+const defaultHealthCheckInterval = 30 * time.Second
+
+// Upstream 上游Plex服务器
+type Upstream struct {
+	Name     string // 名称
+	BaseURL  string // 地址，不含末尾的 /
+	Token    string // 认证授权TOKEN
+	Priority int    // 优先级，数值越小越优先
+
+	healthy   atomic.Bool
+	mu        sync.Mutex
+	lastCheck time.Time
+	lastError string
+}
+
+// UpstreamStatus 上游服务器状态
+type UpstreamStatus struct {
+	Name      string     `json:"name"`
+	Addr      string     `json:"addr"`
+	Priority  int        `json:"priority"`
+	Healthy   bool       `json:"healthy"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// upstreamPool 上游服务器列表，配置变化时重新构建并保留健康状态
+type upstreamPool struct {
+	mu        sync.Mutex
+	snapshot  *config.Snapshot // 构建列表时的配置快照
+	upstreams []*Upstream      // 按优先级排列
+}
+
+var upstreamList = &upstreamPool{}
+
+// list 获取当前配置对应的上游服务器列表
+func (p *upstreamPool) list() []*Upstream {
+	snapshot := config.Current()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.snapshot != snapshot {
+		p.rebuild(snapshot)
+	}
+	return p.upstreams
+}
+
+// rebuild 根据配置快照重新构建上游服务器列表
+func (p *upstreamPool) rebuild(snapshot *config.Snapshot) {
+	previous := make(map[string]*Upstream, len(p.upstreams))
+	for _, upstream := range p.upstreams {
+		previous[upstream.Name+"\x00"+upstream.BaseURL] = upstream
+	}
+
+	settings := append([]config.PlexUpstreamSetting{{
+		Name: constants.PRIMARY_UPSTREAM,
+		Addr: snapshot.PlexServer.ADDR,
+		Auth: snapshot.PlexServer.AUTH,
+	}}, snapshot.PlexServer.Upstreams...)
+
+	upstreams := make([]*Upstream, 0, len(settings))
+	for _, setting := range settings {
+		baseURL := strings.TrimSuffix(setting.Addr, "/")
+		upstream := &Upstream{Name: setting.Name, BaseURL: baseURL, Token: setting.Auth, Priority: setting.Priority}
+		if old, ok := previous[setting.Name+"\x00"+baseURL]; ok {
+			// 地址未变化时沿用健康状态
+			old.mu.Lock()
+			upstream.lastCheck, upstream.lastError = old.lastCheck, old.lastError
+			old.mu.Unlock()
+			upstream.healthy.Store(old.healthy.Load())
+		} else {
+			upstream.healthy.Store(true)
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	sort.SliceStable(upstreams, func(i, j int) bool {
+		return upstreams[i].Priority < upstreams[j].Priority
+	})
+
+	// 移除已删除的服务器的指标
+	metrics.UpstreamUp.Reset()
+	for _, upstream := range upstreams {
+		metrics.UpstreamUp.WithLabelValues(upstream.Name).Set(boolToFloat(upstream.Healthy()))
+	}
+
+	p.snapshot = snapshot
+	p.upstreams = upstreams
+}
+
+// boolToFloat 将布尔值转换为指标取值
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Upstreams 获取全部上游服务器，按优先级排列
+func Upstreams() []*Upstream {
+	return upstreamList.list()
+}
+
+// UpstreamStatuses 获取全部上游服务器的状态
+func UpstreamStatuses() []UpstreamStatus {
+	upstreams := Upstreams()
+	statuses := make([]UpstreamStatus, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		status := UpstreamStatus{
+			Name:     upstream.Name,
+			Addr:     upstream.BaseURL,
+			Priority: upstream.Priority,
+			Healthy:  upstream.Healthy(),
+		}
+		upstream.mu.Lock()
+		if !upstream.lastCheck.IsZero() {
+			lastCheck := upstream.lastCheck
+			status.LastCheck = &lastCheck
+		}
+		status.LastError = upstream.lastError
+		upstream.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SelectUpstreams 获取请求的候选上游服务器，按尝试顺序排列：
+// 健康的服务器在前，其中命中路由规则的服务器优先，其余按优先级排列
+func SelectUpstreams(path string, query url.Values, header http.Header) []*Upstream {
+	routed := matchRoute(config.Current().PlexServer.Routes, path, query, header)
+	candidates := append([]*Upstream(nil), Upstreams()...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Healthy() != b.Healthy() {
+			return a.Healthy()
+		}
+		return a.Name == routed && b.Name != routed
+	})
+	return candidates
+}
+
+// matchRoute 获取请求命中的第一条路由规则的目标服务器名称，未命中时返回空
+func matchRoute(routes []config.PlexRouteRule, path string, query url.Values, header http.Header) string {
+	for _, route := range routes {
+		if route.PathPrefix != "" && !strings.HasPrefix(path, route.PathPrefix) {
+			continue
+		}
+		if route.Section != "" && !matchSection(route.Section, path, query) {
+			continue
+		}
+		if route.Client != "" && !matchClient(route.Client, query, header) {
+			continue
+		}
+		return route.Upstream
+	}
+	return ""
+}
+
+// matchSection 判断请求是否属于指定媒体库
+func matchSection(section, path string, query url.Values) bool {
+	prefix := "/library/sections/" + section
+	if path == prefix || strings.HasPrefix(path, prefix+"/") {
+		return true
+	}
+	return query.Get("librarySectionID") == section || query.Get("sectionID") == section
+}
+
+// matchClient 判断请求是否来自指定客户端
+func matchClient(client string, query url.Values, header http.Header) bool {
+	client = strings.ToLower(client)
+	for _, value := range []string{
+		header.Get("X-Plex-Product"),
+		query.Get("X-Plex-Product"),
+		header.Get("X-Emby-Client"),
+		header.Get("User-Agent"),
+	} {
+		if value != "" && strings.Contains(strings.ToLower(value), client) {
+			return true
+		}
+	}
+	return false
+}
+
+// Healthy 判断服务器是否可用
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load()
+}
+
+// markHealth 记录检查或请求结果，状态变化时输出日志
+func (u *Upstream) markHealth(err error) {
+	u.mu.Lock()
+	u.lastCheck = time.Now()
+	u.lastError = ""
+	if err != nil {
+		u.lastError = err.Error()
+	}
+	u.mu.Unlock()
+
+	healthy := err == nil
+	metrics.UpstreamUp.WithLabelValues(u.Name).Set(boolToFloat(healthy))
+	if u.healthy.Swap(healthy) != healthy {
+		if healthy {
+			logging.Infof("Plex服务器 %s 已恢复: %s", u.Name, u.BaseURL)
+		} else {
+			logging.Warnf("Plex服务器 %s 不可用: %s, %v", u.Name, u.BaseURL, err)
+		}
+	}
+}
+
+// URL 构建该服务器的请求URL
+// 查询参数原样保留（包括重复参数），withToken 为 true 且未携带令牌时附加该服务器的令牌
+func (u *Upstream) URL(path string, query url.Values, withToken bool) string {
+	parsed, err := url.Parse(u.BaseURL + path)
+	if err != nil {
+		logging.Errorf("解析URL失败: %v", err)
+		return ""
+	}
+
+	q := parsed.Query()
+	for key, values := range query {
+		for _, value := range values {
+			q.Add(key, value)
+		}
+	}
+
+	if tokenParam := CurrentBackend().TokenParam(); withToken && u.Token != "" && q.Get(tokenParam) == "" {
+		q.Set(tokenParam, u.Token)
+	}
+
+	parsed.RawQuery = q.Encode()
+	return parsed.String()
+}
+
+// do 向该服务器发送请求，withToken 为 true 且请求未携带令牌时附加该服务器的令牌
+func (u *Upstream) do(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader, contentLength int64, withToken bool) (*http.Response, error) {
+	plexURL := u.URL(path, query, withToken && !CurrentBackend().HasClientToken(query, header))
+	if plexURL == "" {
+		return nil, fmt.Errorf("构建Plex URL失败")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, plexURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	if body != nil {
+		req.ContentLength = contentLength
+	}
+
+	// 设置请求头，保留重复的请求头
+	if header != nil {
+		req.Header = header.Clone()
+	}
+
+	// 仅在客户端未提供时设置默认请求头
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "PlexWarp/1.0")
+	}
+
+	resp, err := PlexClient.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		// 错误信息中的URL可能包含令牌，仅保留地址及路径
+		urlErr.URL = u.BaseURL + path
+	}
+	return resp, err
+}
+
+// CheckConnection 检查与该服务器的连接
+func (u *Upstream) CheckConnection() error {
+	resp, err := u.do(context.Background(), http.MethodGet, CurrentBackend().HealthPath(), nil, nil, nil, 0, true)
+	if err != nil {
+		return fmt.Errorf("连接Plex服务器失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Plex服务器响应异常: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CheckToken 检查该服务器配置的令牌是否有效
+func (u *Upstream) CheckToken() error {
+	if u.Token == "" {
+		return fmt.Errorf("未配置Plex令牌")
+	}
+
+	resp, err := u.do(context.Background(), http.MethodGet, CurrentBackend().TokenCheckPath(), nil, nil, nil, 0, true)
+	if err != nil {
+		return fmt.Errorf("连接Plex服务器失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("Plex令牌无效: %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("Plex服务器响应异常: %d", resp.StatusCode)
+	}
+}
+
+// checkHealth 健康检查，请求后端的健康检查接口，服务器能够正常响应即视为可用
+func (u *Upstream) checkHealth(timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	defer cancel()
+
+	resp, err := u.do(ctx, http.MethodGet, CurrentBackend().HealthPath(), nil, nil, nil, 0, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("Plex服务器响应异常: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// StartHealthCheck 启动定时健康检查，检查间隔及开关支持热重载
+func StartHealthCheck() {
+	go func() {
+		for {
+			interval := config.Current().PlexServer.HealthCheck.Interval
+			if interval <= 0 {
+				interval = defaultHealthCheckInterval
+			}
+			time.Sleep(interval)
+
+			setting := config.Current().PlexServer.HealthCheck
+			if !setting.Enable {
+				continue
+			}
+			var wg sync.WaitGroup
+			for _, upstream := range Upstreams() {
+				wg.Add(1)
+				go func(upstream *Upstream) {
+					defer wg.Done()
+					upstream.markHealth(upstream.checkHealth(setting.Timeout))
+				}(upstream)
+			}
+			wg.Wait()
+		}
+	}()
+}
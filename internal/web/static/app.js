@@ -0,0 +1,195 @@
+// PlexWarp 控制台，定期从 /api 获取状态并渲染
+(function () {
+  'use strict';
+
+  var REFRESH_INTERVAL = 5000;
+  var KEY_STORAGE = 'plexwarp.api_key';
+
+  var $ = function (id) { return document.getElementById(id); };
+
+  // 请求接口，需要认证时携带保存的API密钥，启用 auth.plex_owner 时也可以是Plex令牌
+  function api(path) {
+    var headers = {};
+    var key = localStorage.getItem(KEY_STORAGE);
+    if (key) {
+      headers['X-Api-Key'] = key;
+      headers['X-Plex-Token'] = key;
+    }
+    return fetch('/api/' + path, { headers: headers }).then(function (resp) {
+      if (resp.status === 401) {
+        $('auth').hidden = false;
+        throw new Error('需要认证');
+      }
+      if (resp.status === 403) {
+        return resp.json().then(function (body) {
+          throw new Error(body.error);
+        });
+      }
+      if (!resp.ok) {
+        throw new Error(path + ': HTTP ' + resp.status);
+      }
+      return resp.json();
+    });
+  }
+
+  // 创建单元格，内容一律作为文本插入
+  function cell(text, className) {
+    var td = document.createElement('td');
+    td.textContent = text === undefined || text === null ? '' : String(text);
+    if (className) {
+      td.className = className;
+    }
+    return td;
+  }
+
+  // 渲染表格，rows 为空时显示占位行
+  function render(id, items, toCells, columns) {
+    var body = $(id);
+    body.textContent = '';
+    if (!items || items.length === 0) {
+      var tr = document.createElement('tr');
+      var td = cell('暂无数据', 'empty');
+      td.colSpan = columns;
+      tr.appendChild(td);
+      body.appendChild(tr);
+      return;
+    }
+    items.forEach(function (item) {
+      var tr = document.createElement('tr');
+      toCells(item).forEach(function (td) { tr.appendChild(td); });
+      body.appendChild(tr);
+    });
+  }
+
+  function formatBytes(n) {
+    var units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    var i = 0;
+    while (n >= 1024 && i < units.length - 1) {
+      n /= 1024;
+      i++;
+    }
+    return (i === 0 ? n : n.toFixed(1)) + ' ' + units[i];
+  }
+
+  function formatDuration(ms) {
+    var s = Math.max(0, Math.floor(ms / 1000));
+    var h = Math.floor(s / 3600);
+    var m = Math.floor((s % 3600) / 60);
+    s = s % 60;
+    return (h ? h + ':' : '') + String(m).padStart(h ? 2 : 1, '0') + ':' + String(s).padStart(2, '0');
+  }
+
+  function formatTime(value) {
+    return value ? new Date(value).toLocaleTimeString() : '';
+  }
+
+  function describeRule(set, rule) {
+    switch (set) {
+      case 'path_mapping':
+        return rule.from + ' → ' + rule.to;
+      case 'symlink':
+        return rule.path + ' → ' + rule.target;
+      default:
+        return rule.match_type + ' ' + (rule.patterns || []).join(', ') + ' → ' + rule.action;
+    }
+  }
+
+  function refreshUpstreams() {
+    return api('upstreams').then(function (upstreams) {
+      render('upstreams', upstreams, function (u) {
+        return [
+          cell(u.name),
+          cell(u.addr, 'wrap'),
+          cell(u.priority),
+          cell(u.healthy ? '正常' : '不可用' + (u.last_error ? '：' + u.last_error : ''), u.healthy ? 'ok' : 'bad wrap'),
+          cell(formatTime(u.last_check), 'muted')
+        ];
+      }, 5);
+    });
+  }
+
+  function refreshSessions() {
+    return api('sessions').then(function (sessions) {
+      var now = Date.now();
+      render('sessions', sessions, function (s) {
+        return [
+          cell(s.client),
+          cell(s.product),
+          cell(s.source),
+          cell(s.path, 'wrap'),
+          cell(formatDuration(now - new Date(s.started_at).getTime())),
+          cell(formatBytes(s.bytes))
+        ];
+      }, 6);
+    });
+  }
+
+  function refreshDecisions() {
+    return api('decisions').then(function (decisions) {
+      render('decisions', decisions.slice(0, 20), function (d) {
+        var failed = d.action === 'error' || d.action === 'fallback';
+        return [
+          cell(formatTime(d.time), 'muted'),
+          cell(d.file_path, 'wrap'),
+          cell(d.action, failed ? 'bad' : 'ok'),
+          cell(d.error || d.link, 'wrap')
+        ];
+      }, 4);
+    });
+  }
+
+  function refreshStats() {
+    return api('stats').then(function (stats) {
+      render('rule-hits', stats.rule_hits, function (h) {
+        return [cell(h.set), cell(h.index), cell(describeRule(h.set, h.rule), 'wrap'), cell(h.hits)];
+      }, 4);
+
+      var index = stats.part_index;
+      var lookups = index.hits + index.misses;
+      var entries = [
+        ['分段索引条目', index.entries],
+        ['已索引的媒体库分区', index.sections],
+        ['分段索引命中率', lookups ? (index.hits / lookups * 100).toFixed(1) + '%（' + index.hits + ' / ' + lookups + '）' : '—'],
+        ['链接探测缓存', stats.probes.cached],
+        ['链接探测记录', stats.probes.history]
+      ];
+      var dl = $('cache');
+      dl.textContent = '';
+      entries.forEach(function (entry) {
+        var dt = document.createElement('dt');
+        var dd = document.createElement('dd');
+        dt.textContent = entry[0];
+        dd.textContent = String(entry[1]);
+        dl.appendChild(dt);
+        dl.appendChild(dd);
+      });
+    });
+  }
+
+  function refresh() {
+    Promise.all([refreshUpstreams(), refreshSessions(), refreshDecisions(), refreshStats()])
+      .then(function () {
+        $('error').hidden = true;
+        $('auth').hidden = true;
+        $('updated').textContent = '更新于 ' + new Date().toLocaleTimeString();
+      })
+      .catch(function (err) {
+        $('error').textContent = err.message;
+        $('error').hidden = false;
+      });
+  }
+
+  $('auth').addEventListener('submit', function (event) {
+    event.preventDefault();
+    localStorage.setItem(KEY_STORAGE, $('api-key').value);
+    $('api-key').value = '';
+    refresh();
+  });
+
+  api('version').then(function (version) {
+    $('version').textContent = 'v' + version.AppVersion;
+  }).catch(function () {});
+
+  refresh();
+  setInterval(refresh, REFRESH_INTERVAL);
+})();
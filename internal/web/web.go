@@ -0,0 +1,38 @@
+package web
+
+import (
+	"PlexWarp/internal/config"
+	"embed"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// 内置的控制台页面
+//
+//go:embed static
+var embedded embed.FS
+
+// Handler 控制台静态文件处理器
+// config.StaticDir 中存在同名文件时优先使用，可用于自定义页面
+func Handler() http.Handler {
+	assets, err := fs.Sub(embedded, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(overlayFS{fallback: assets}))
+}
+
+// overlayFS 优先从静态文件目录读取文件，不存在时使用内置文件
+type overlayFS struct {
+	fallback fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if config.StaticDir != "" {
+		if file, err := os.DirFS(config.StaticDir).Open(name); err == nil {
+			return file, nil
+		}
+	}
+	return o.fallback.Open(name)
+}
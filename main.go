@@ -5,11 +5,15 @@ import (
 	"PlexWarp/internal/config"
 	"PlexWarp/internal/handler"
 	"PlexWarp/internal/logging"
+	"PlexWarp/internal/middleware"
 	"PlexWarp/internal/router"
 	"PlexWarp/internal/service"
 	"PlexWarp/utils"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -21,15 +25,17 @@ import (
 )
 
 var (
-	isDebug     bool   // 开启调试模式
-	showVersion bool   // 显示版本信息
-	configPath  string // 配置文件路径
+	isDebug     bool       // 开启调试模式
+	showVersion bool       // 显示版本信息
+	configPath  string     // 配置文件路径
+	overrides   stringList // 命令行配置覆盖项
 )
 
 func init() {
 	flag.BoolVar(&showVersion, "version", false, "显示版本信息")
 	flag.BoolVar(&isDebug, "debug", false, "是否启用调试模式")
 	flag.StringVar(&configPath, "config", "", "指定配置文件路径")
+	flag.Var(&overrides, "set", "覆盖配置项，格式为 key=value，可重复指定，如 -set plex_server.addr=http://plex:32400")
 	flag.Parse()
 
 	fmt.Print(constants.LOGO)
@@ -43,16 +49,24 @@ func main() {
 		return
 	}
 
-	gin.SetMode(gin.ReleaseMode)
+	// 命令行配置覆盖
+	for _, assignment := range overrides {
+		if err := config.SetOverride(assignment); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
 
-	if isDebug {
-		logging.SetLevel(logrus.DebugLevel)
-		fmt.Println("已启用调试模式")
+	// 子命令
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(args))
 	}
 
+	gin.SetMode(gin.ReleaseMode)
+
 	signChan := make(chan os.Signal, 1)
 	errChan := make(chan error, 1)
-	signal.Notify(signChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signChan, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, reopenSignals...)...)
 	defer func() {
 		fmt.Println("PlexWarp 已退出")
 	}()
@@ -61,27 +75,129 @@ func main() {
 		fmt.Println("配置初始化失败：", err)
 		return
 	}
-	logging.Init()                                                                         // 初始化日志
-	logging.Infof("Plex服务器地址：%s", config.PlexServer.ADDR)                              // 日志打印
+	if err := logging.Init(); err != nil { // 初始化日志
+		fmt.Println("日志初始化失败：", err)
+		return
+	}
+	defer logging.Close()
+	if isDebug {
+		logging.SetLevel(logrus.DebugLevel)
+		fmt.Println("已启用调试模式")
+	}
+	logConfigWarnings()
+	logging.Infof("Plex服务器地址：%s", config.Current().PlexServer.ADDR)                              // 日志打印
 	service.InitPlexService()                                                              // 初始化Plex服务
 	if err := handler.Init(); err != nil {                                                 // 初始化处理器
 		logging.Error("Plex处理器初始化失败：", err)
 		return
 	}
+	service.StartHealthCheck() // 启动Plex服务器健康检查
+	service.InitPartIndex() // 初始化分段索引
+	config.Watch(onConfigReload) // 监听配置文件变化
 
-	logging.Info("PlexWarp 监听端口：", config.Port)
-	ginR := router.InitRouter() // 路由初始化
+	logging.Info("PlexWarp 监听端口：", config.Current().Port)
+	server := &http.Server{
+		Addr:     config.ListenAddr(),
+		Handler:  router.InitRouter(), // 路由初始化
+		ErrorLog: logging.ErrorLog(),
+	}
 	logging.Info("PlexWarp 启动成功")
 	go func() {
-		if err := ginR.Run(config.ListenAddr()); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- err
 		}
 	}()
 
-	select {
-	case sig := <-signChan:
-		logging.Info("PlexWarp 正在退出，信号：", sig)
-	case err := <-errChan:
-		logging.Error("PlexWarp 运行出错：", err)
+	for {
+		select {
+		case sig := <-signChan:
+			if sig == syscall.SIGHUP {
+				logging.Info("收到 SIGHUP 信号，重新加载配置")
+				changes, err := config.Reload()
+				if err == nil && len(changes) == 0 {
+					logging.Info("配置无变化")
+				}
+				onConfigReload(changes, err)
+				continue
+			}
+			if isReopenSignal(sig) {
+				logging.Reopen()
+				continue
+			}
+			logging.Info("PlexWarp 正在退出，信号：", sig)
+			shutdown(server, signChan)
+		case err := <-errChan:
+			logging.Error("PlexWarp 运行出错：", err)
+		}
+		return
 	}
-}
\ No newline at end of file
+}
+
+// shutdown 停止接受新请求，等待进行中的请求完成，超过等待时间或再次收到退出信号时强制关闭
+func shutdown(server *http.Server, signChan <-chan os.Signal) {
+	middleware.StartDraining()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timeout := config.Current().Shutdown.DrainTimeout
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		logging.Infof("等待 %d 个进行中的请求完成，最长 %s，再次发送退出信号可立即退出", middleware.ActiveRequests(), timeout)
+	} else {
+		logging.Infof("等待 %d 个进行中的请求完成，再次发送退出信号可立即退出", middleware.ActiveRequests())
+	}
+
+	go func() {
+		for sig := range signChan {
+			if sig != syscall.SIGHUP && !isReopenSignal(sig) {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logging.Warnf("仍有 %d 个请求未完成，强制关闭：%v", middleware.ActiveRequests(), err)
+		server.Close()
+		return
+	}
+	logging.Info("全部请求已完成")
+}
+
+// runCommand 执行子命令，返回进程退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "check":
+		return runCheck(args[1:])
+	case "resolve":
+		return runResolve(args[1:])
+	default:
+		fmt.Printf("未知的子命令：%s\n可用子命令：\n  check    检查配置文件及依赖服务\n  resolve  模拟strm重定向并输出决策过程\n", args[0])
+		return 2
+	}
+}
+
+// onConfigReload 配置重载回调，记录变化项或失败原因
+func onConfigReload(changes []string, err error) {
+	if err != nil {
+		logging.Error("配置重载失败，继续使用原配置：", err)
+		return
+	}
+	logConfigWarnings()
+	if len(changes) == 0 {
+		return
+	}
+
+	logging.Infof("配置已重载，共 %d 项变化", len(changes))
+	for _, change := range changes {
+		logging.Info("  ", change)
+	}
+}
+
+// logConfigWarnings 打印加载配置时的警告
+func logConfigWarnings() {
+	for _, warning := range config.Warnings() {
+		logging.Warn("配置警告：", warning)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"PlexWarp/internal/config"
+	"PlexWarp/internal/service"
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// runResolve 模拟一次strm重定向，逐步输出决策过程，不会写入任何响应
+// 参数为媒体文件在服务器上的路径，或Plex分段ID、Jellyfin/Emby条目ID
+func runResolve(args []string) int {
+	fs := flag.NewFlagSet("resolve", flag.ExitOnError)
+	path := fs.String("config", configPath, "指定配置文件路径")
+	requestPath := fs.String("request", "", "模拟的客户端请求路径，用于判断是否为转码请求，默认为直接播放请求")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法：%s resolve [选项] <文件路径|分段ID|条目ID>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	target := fs.Arg(0)
+
+	if err := config.Init(*path); err != nil {
+		fmt.Println("配置初始化失败：", err)
+		return 1
+	}
+
+	step := 0
+	printStep := func(name, detail string) {
+		step++
+		fmt.Printf("%2d. %s: %s\n", step, name, detail)
+	}
+
+	// ID需要先通过媒体服务器查询媒体文件路径
+	backend := service.CurrentBackend()
+	filePath := target
+	if !strings.HasPrefix(target, "/") {
+		if *requestPath == "" {
+			*requestPath = backend.PlayPath(target)
+		}
+		request, err := url.Parse(*requestPath)
+		if err != nil {
+			fmt.Println("无效的请求路径：", err)
+			return 2
+		}
+		mediaRequest := backend.ParseMediaRequest(request.Path, request.Query())
+		if mediaRequest == nil {
+			fmt.Printf("请求路径 %s 不是%s播放请求\n", *requestPath, backend.Type())
+			return 2
+		}
+
+		service.InitPlexService()
+		file, err := backend.LookupFile(context.Background(), mediaRequest)
+		if err != nil {
+			printStep("条目查询", fmt.Sprintf("%s %s 查询失败: %v", backend.Type(), target, err))
+			return 1
+		}
+		printStep("条目查询", fmt.Sprintf("%s %s -> %s", backend.Type(), target, file))
+		filePath = file
+	}
+	if *requestPath == "" {
+		*requestPath = backend.PlayPath("0")
+	}
+
+	decision := service.NewStrmService().Resolve(context.Background(), filePath, *requestPath)
+	for _, s := range decision.Steps {
+		printStep(s.Name, s.Detail)
+	}
+
+	fmt.Println()
+	switch decision.Action {
+	case service.DecisionPassthrough:
+		fmt.Println("最终动作: passthrough，按原请求代理到Plex")
+	case service.DecisionLocal:
+		fmt.Printf("最终动作: local，由PlexWarp直接提供 %s\n", decision.Link)
+	case service.DecisionRedirect:
+		fmt.Printf("最终动作: redirect，302重定向到 %s\n", decision.Link)
+	case service.DecisionProxy:
+		fmt.Printf("最终动作: proxy，由PlexWarp代理 %s\n", decision.Link)
+	case service.DecisionFallback:
+		fmt.Printf("最终动作: fallback，回退到原请求（%s）\n", decision.Error)
+	default:
+		fmt.Printf("最终动作: error，返回500（%s）\n", decision.Error)
+		return 1
+	}
+	return 0
+}
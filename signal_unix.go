@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// 重新打开日志文件的信号，配合外部 logrotate 使用
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
+
+// isReopenSignal 判断是否为重新打开日志文件的信号
+func isReopenSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR1
+}
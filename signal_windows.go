@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows 不支持 SIGUSR1，日志文件由内置的切割功能管理
+var reopenSignals []os.Signal
+
+// isReopenSignal 判断是否为重新打开日志文件的信号
+func isReopenSignal(sig os.Signal) bool {
+	return false
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"net"
 	"strings"
 )
 
@@ -37,4 +38,42 @@ func RemoveEmpty(slice []string) []string {
 		}
 	}
 	return result
-}
\ No newline at end of file
+}
+
+// IsHopByHopHeader 检查是否为逐跳头部
+func IsHopByHopHeader(header string) bool {
+	hopByHopHeaders := []string{
+		"Connection",
+		"Keep-Alive",
+		"Proxy-Authenticate",
+		"Proxy-Authorization",
+		"Te",
+		"Trailers",
+		"Transfer-Encoding",
+		"Upgrade",
+	}
+
+	header = strings.ToLower(header)
+	for _, h := range hopByHopHeaders {
+		if strings.ToLower(h) == header {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDR 解析CIDR网段，单个IP地址视为仅包含该地址的网段
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: s}
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	return ipNet, err
+}